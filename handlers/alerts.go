@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/uber/h3-go/v3"
+)
+
+// Trailing baseline, in days before the evaluated 24 hours
+const alertBaselineDays = 14
+
+// A drop is significant when the last 24 hours are alertZScore standard deviations
+// under the baseline mean and at most alertMaxRatio of it
+const alertZScore = -3
+const alertMaxRatio = 0.5
+
+// Peers keeping alertPeerRatio of their baseline mean the drop is local to the hotspot
+const alertPeerRatio = 0.75
+
+// StartRewardAlertJob evaluates the hotspot rewards every ALERTS_REFRESH_MINUTES
+// (default 60). Hotspots earning less than ALERTS_MIN_BASELINE bones per day
+// (default 1000000) are ignored.
+func StartRewardAlertJob() {
+
+	_, err := db.DB.Exec(`CREATE TABLE IF NOT EXISTS reward_alerts (
+							address TEXT NOT NULL,
+							owner TEXT,
+							day BIGINT NOT NULL,
+							rewards BIGINT,
+							baseline DOUBLE PRECISION,
+							stddev DOUBLE PRECISION,
+							z_score DOUBLE PRECISION,
+							ratio DOUBLE PRECISION,
+							peers INTEGER,
+							peer_ratio DOUBLE PRECISION,
+							hex_wide BOOLEAN,
+							created_at BIGINT,
+							updated_at BIGINT,
+							PRIMARY KEY (address, day)
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartRewardAlertJob] %v", err)
+		return
+	}
+
+	_, err = db.DB.Exec(`CREATE INDEX IF NOT EXISTS reward_alerts_owner_idx ON reward_alerts (owner, day)`)
+	if err != nil {
+		log.Printf("[ERROR StartRewardAlertJob] %v", err)
+	}
+
+	// Complete UTC days of rewards per gateway, filled incrementally for the baselines
+	_, err = db.DB.Exec(`CREATE TABLE IF NOT EXISTS reward_alert_days (
+							gateway TEXT NOT NULL,
+							day BIGINT NOT NULL,
+							amount BIGINT,
+							PRIMARY KEY (day, gateway)
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartRewardAlertJob] %v", err)
+		return
+	}
+
+	interval := envInt("ALERTS_REFRESH_MINUTES", 60)
+
+	minBaseline := 1000000.0
+	if v, err := strconv.ParseFloat(os.Getenv("ALERTS_MIN_BASELINE"), 64); err == nil && v > 0 {
+		minBaseline = v
+	}
+
+	go func() {
+		for {
+			// Owners and hex peers come from the density model
+			if !densityReady() {
+				time.Sleep(time.Minute)
+				continue
+			}
+
+			evaluateRewardAlerts(minBaseline)
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+type rewardBaseline struct {
+	current float64
+	mean    float64
+	stddev  float64
+}
+
+// updateRewardAlertDays adds the complete UTC days missing from reward_alert_days,
+// so each run only scans the rewards of the days that ended since the last one
+func updateRewardAlertDays(today int64) error {
+
+	var last sql.NullInt64
+	err := db.DB.QueryRow(`SELECT MAX(day) FROM reward_alert_days`).Scan(&last)
+	if err != nil {
+		return err
+	}
+
+	// One spare day, the baseline ends a day before the evaluated 24 hours
+	from := today - (alertBaselineDays+1)*86400
+	if last.Valid && last.Int64+86400 > from {
+		from = last.Int64 + 86400
+	}
+
+	if from < today {
+		_, err = db.DB.Exec(`INSERT INTO reward_alert_days
+								SELECT
+									gateway,
+									FLOOR(time / 86400)::BIGINT * 86400 AS day,
+									SUM(amount)
+								FROM
+									rewards
+								WHERE
+									time >= $1
+									AND time < $2
+									AND gateway IS NOT NULL
+								GROUP BY
+									gateway,
+									day
+							ON CONFLICT (day, gateway) DO UPDATE SET
+								amount = EXCLUDED.amount`, from, today)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.DB.Exec(`DELETE FROM reward_alert_days WHERE day < $1`, today-(alertBaselineDays+1)*86400)
+
+	return err
+}
+
+// evaluateRewardAlerts compares the rewards of the last 24 hours with the daily
+// rewards of the alertBaselineDays complete UTC days before them, days without
+// rewards counting as zero
+func evaluateRewardAlerts(minBaseline float64) {
+
+	start := time.Now()
+	now := start.Unix()
+	today := now - now%86400
+
+	if err := updateRewardAlertDays(today); err != nil {
+		log.Printf("[ERROR evaluateRewardAlerts] %v", err)
+		return
+	}
+
+	// The baseline ends with the day the evaluated 24 hours start in
+	baselineEnd := (now - 86400) - (now-86400)%86400
+
+	rows, err := db.DB.Query(`WITH baseline AS (
+								SELECT
+									gateway,
+									SUM(amount)::DOUBLE PRECISION AS amount,
+									SUM(amount::DOUBLE PRECISION * amount) AS squares
+								FROM
+									reward_alert_days
+								WHERE
+									day >= $2 - $3 * 86400
+									AND day < $2
+								GROUP BY
+									gateway
+							),
+							current AS (
+								SELECT
+									gateway,
+									SUM(amount)::DOUBLE PRECISION AS amount
+								FROM
+									rewards
+								WHERE
+									time >= $1 - 86400
+									AND gateway IS NOT NULL
+								GROUP BY
+									gateway
+							)
+							SELECT
+								b.gateway,
+								COALESCE(c.amount, 0),
+								b.amount,
+								b.squares
+							FROM
+								baseline b
+								LEFT JOIN current c ON c.gateway = b.gateway`, now, baselineEnd, alertBaselineDays)
+	if err != nil {
+		log.Printf("[ERROR evaluateRewardAlerts] %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	baselines := make(map[string]rewardBaseline, 0)
+
+	var gateway sql.NullString
+	var current, sum, squares sql.NullFloat64
+
+	for rows.Next() {
+
+		err := rows.Scan(&gateway, &current, &sum, &squares)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+
+		mean := sum.Float64 / alertBaselineDays
+		variance := squares.Float64/alertBaselineDays - mean*mean
+
+		baselines[gateway.String] = rewardBaseline{current.Float64, mean, math.Sqrt(math.Max(variance, 0))}
+	}
+	rows.Close()
+
+	hotspots := make(map[string]indexedHotspot, 0)
+	for _, hotspot := range allIndexedHotspots() {
+		hotspots[hotspot.Address] = hotspot
+	}
+
+	day := start.UTC().Truncate(24 * time.Hour).Unix()
+	alerts := 0
+
+	for address, baseline := range baselines {
+
+		if baseline.mean < minBaseline {
+			continue
+		}
+
+		// A flat baseline still allows some variation
+		stddev := math.Max(baseline.stddev, baseline.mean*0.1)
+		zScore := (baseline.current - baseline.mean) / stddev
+		ratio := baseline.current / baseline.mean
+
+		if zScore > alertZScore || ratio > alertMaxRatio {
+			continue
+		}
+
+		// Compare with the other hotspots of the res-8 hex
+		hotspot := hotspots[address]
+		peers := 0
+		var peersCurrent, peersMean float64
+
+		if hotspot.Index != 0 {
+			for _, peer := range hotspotsInRes8(h3.ToParent(hotspot.Index, 8)) {
+				if peer.Address == address {
+					continue
+				}
+				peers++
+				peersCurrent += baselines[peer.Address].current
+				peersMean += baselines[peer.Address].mean
+			}
+		}
+
+		peerRatio := 1.0
+		if peersMean > 0 {
+			peerRatio = peersCurrent / peersMean
+		}
+
+		_, err := db.DB.Exec(`INSERT INTO reward_alerts
+								(address, owner, day, rewards, baseline, stddev, z_score, ratio, peers, peer_ratio, hex_wide, created_at, updated_at)
+							VALUES
+								($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12)
+							ON CONFLICT (address, day) DO UPDATE SET
+								owner = EXCLUDED.owner,
+								rewards = EXCLUDED.rewards,
+								baseline = EXCLUDED.baseline,
+								stddev = EXCLUDED.stddev,
+								z_score = EXCLUDED.z_score,
+								ratio = EXCLUDED.ratio,
+								peers = EXCLUDED.peers,
+								peer_ratio = EXCLUDED.peer_ratio,
+								hex_wide = EXCLUDED.hex_wide,
+								updated_at = EXCLUDED.updated_at`,
+			address, hotspot.Owner, day, int64(baseline.current), baseline.mean, baseline.stddev, zScore, ratio, peers, peerRatio, peers > 0 && peerRatio < alertPeerRatio, now)
+		if err != nil {
+			log.Printf("[ERROR evaluateRewardAlerts] %v", err)
+			continue
+		}
+
+		alerts++
+	}
+
+	log.Printf("Reward alerts: %v drops found in %v", alerts, time.Since(start))
+}
+
+func GetHotspotAlerts(c echo.Context) error {
+	return rewardAlertsHandler(c, "address")
+}
+
+func GetWalletAlerts(c echo.Context) error {
+	return rewardAlertsHandler(c, "owner")
+}
+
+func rewardAlertsHandler(c echo.Context, column string) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	days := 30
+	if c.QueryParam("days") != "" {
+		maxDays := envInt("ALERTS_MAX_DAYS", 366)
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > maxDays {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", maxDays))
+		}
+		days = v
+	}
+
+	alerts := getRewardAlerts(column, hash, days)
+
+	return c.JSON(200, alerts)
+}
+
+// getRewardAlerts lists the alerts of a hotspot or of every hotspot of an owner,
+// newest first. column is "address" or "owner", never user input.
+func getRewardAlerts(column string, hash string, days int) []RewardAlert {
+
+	alerts := make([]RewardAlert, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("reward-alerts-%v-%v-%v", column, hash, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			rows, err := db.DB.Query(fmt.Sprintf(`SELECT
+													address,
+													owner,
+													day,
+													rewards,
+													baseline,
+													z_score,
+													ratio,
+													peers,
+													peer_ratio,
+													hex_wide,
+													created_at,
+													updated_at
+												FROM
+													reward_alerts
+												WHERE
+													%v = $1
+													AND day >= $2
+												ORDER BY
+													day DESC,
+													z_score ASC
+												LIMIT 500`, column), hash, time.Now().AddDate(0, 0, -days).Unix())
+			if err != nil {
+				log.Printf("[ERROR getRewardAlerts] %v", err)
+				return alerts
+			}
+
+			defer rows.Close()
+
+			var address, owner sql.NullString
+			var day, rewards, peers, createdAt, updatedAt sql.NullInt64
+			var baseline, zScore, ratio, peerRatio sql.NullFloat64
+			var hexWide sql.NullBool
+
+			for rows.Next() {
+
+				err := rows.Scan(&address, &owner, &day, &rewards, &baseline, &zScore, &ratio, &peers, &peerRatio, &hexWide, &createdAt, &updatedAt)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				alerts = append(alerts, RewardAlert{
+					address.String,
+					owner.String,
+					day.Int64,
+					rewards.Int64,
+					baseline.Float64,
+					zScore.Float64,
+					ratio.Float64,
+					int(peers.Int64),
+					peerRatio.Float64,
+					hexWide.Bool,
+					createdAt.Int64,
+					updatedAt.Int64,
+				})
+			}
+			rows.Close()
+
+			if err := enc.Encode(alerts); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 300})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&alerts); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return alerts
+}
@@ -0,0 +1,582 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/uber/h3-go/v3"
+)
+
+// Flag types
+const flagImplausibleRSSI = "implausible_rssi"
+const flagOwnerCluster = "owner_cluster"
+const flagIdenticalTimestamps = "identical_timestamps"
+const flagRelocations = "frequent_relocations"
+
+var flagTypes = map[string]bool{
+	flagImplausibleRSSI:     true,
+	flagOwnerCluster:        true,
+	flagIdenticalTimestamps: true,
+	flagRelocations:         true,
+}
+
+// Highest EIRP allowed in any region (dBm), a witness can't hear a beacon
+// louder than this plus its own antenna gain minus the free space path loss
+const anomalyMaxEIRP = 36
+
+// Antenna gain (dBi x 10) assumed for witnesses missing from gateway_inventory,
+// the chain default
+const anomalyDefaultGain = 12
+
+// Under this distance (m) the hex resolution makes the path loss meaningless
+const anomalyMinDistance = 300
+
+// Occurrences needed before a hotspot is flagged
+const anomalyMinOccurrences = 3
+
+// A cluster needs this many witness relations, and this share with the same owner
+const anomalyClusterMinRelations = 5
+const anomalyClusterOwnerShare = 0.9
+
+// Moves within anomalyRelocationDays needed for the relocation flag
+const anomalyRelocationMoves = 3
+const anomalyRelocationDays = 90
+
+const anomalyMaxEvidence = 10
+
+// anomalyEvidence counts the occurrences of a hotspot and keeps the first
+// anomalyMaxEvidence of them
+type anomalyEvidence struct {
+	count    int
+	evidence []FlagEvidence
+}
+
+func (e *anomalyEvidence) add(evidence FlagEvidence) {
+
+	e.count++
+	if len(e.evidence) < anomalyMaxEvidence {
+		e.evidence = append(e.evidence, evidence)
+	}
+}
+
+// anomalyDetector accumulates the PoC receipts and turns them into flags
+type anomalyDetector struct {
+	rssi       map[string]*anomalyEvidence
+	timestamps map[string]*anomalyEvidence
+	relations  map[string]map[string]bool // hotspot -> peers it beaconed to or witnessed
+	owners     map[string]string
+	gains      map[string]int64 // dBi x 10
+}
+
+func newAnomalyDetector(gains map[string]int64) *anomalyDetector {
+	return &anomalyDetector{
+		rssi:       make(map[string]*anomalyEvidence, 0),
+		timestamps: make(map[string]*anomalyEvidence, 0),
+		relations:  make(map[string]map[string]bool, 0),
+		owners:     make(map[string]string, 0),
+		gains:      gains,
+	}
+}
+
+// getAntennaGains returns the gateway_inventory gains (dBi x 10) of the hotspots,
+// of every hotspot when addresses is nil
+func getAntennaGains(addresses []string) map[string]int64 {
+
+	gains := make(map[string]int64, 0)
+
+	var rows *sql.Rows
+	var err error
+
+	if addresses == nil {
+		rows, err = db.DB.Query(`SELECT address, gain FROM gateway_inventory`)
+	} else {
+		rows, err = db.DB.Query(`SELECT address, gain FROM gateway_inventory WHERE address = ANY($1)`, pq.Array(addresses))
+	}
+	if err != nil {
+		log.Printf("[ERROR getAntennaGains] %v", err)
+		return gains
+	}
+
+	defer rows.Close()
+
+	var address sql.NullString
+	var gain sql.NullInt64
+
+	for rows.Next() {
+
+		if err := rows.Scan(&address, &gain); err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+
+		if gain.Valid {
+			gains[address.String] = gain.Int64
+		}
+	}
+
+	return gains
+}
+
+func (d *anomalyDetector) gain(address string) float64 {
+
+	if gain, ok := d.gains[address]; ok {
+		return float64(gain) / 10
+	}
+
+	return anomalyDefaultGain / 10.0
+}
+
+func addAnomalyEvidence(m map[string]*anomalyEvidence, address string, evidence FlagEvidence) {
+
+	if m[address] == nil {
+		m[address] = &anomalyEvidence{}
+	}
+	m[address].add(evidence)
+}
+
+// freeSpacePathLoss in dB, distance in meters and frequency in MHz
+func freeSpacePathLoss(distance float64, frequency float64) float64 {
+	return 20*math.Log10(distance/1000) + 20*math.Log10(frequency) + 32.44
+}
+
+func (d *anomalyDetector) addRelation(a string, b string) {
+
+	if d.relations[a] == nil {
+		d.relations[a] = make(map[string]bool, 0)
+	}
+	d.relations[a][b] = true
+}
+
+func (d *anomalyDetector) addReceipt(txHash string, timestamp int64, receipt *WitnessStruct) {
+
+	for _, path := range receipt.Path {
+
+		if path.Challengee == "" {
+			continue
+		}
+
+		if path.ChallengeeOwner != "" {
+			d.owners[path.Challengee] = path.ChallengeeOwner
+		}
+
+		witnessTimestamps := make(map[int64][]string, 0)
+
+		for _, witness := range path.Witnesses {
+
+			if witness.Owner != "" {
+				d.owners[witness.Gateway] = witness.Owner
+			}
+
+			d.addRelation(path.Challengee, witness.Gateway)
+			d.addRelation(witness.Gateway, path.Challengee)
+
+			if witness.Timestamp != 0 {
+				witnessTimestamps[witness.Timestamp] = append(witnessTimestamps[witness.Timestamp], witness.Gateway)
+			}
+
+			if path.ChallengeeLocation == "" || witness.Location == "" || witness.Frequency == 0 {
+				continue
+			}
+
+			distance := h3.PointDistM(h3.ToGeo(h3.FromString(path.ChallengeeLocation)), h3.ToGeo(h3.FromString(witness.Location)))
+			if distance < anomalyMinDistance {
+				continue
+			}
+
+			maxRSSI := anomalyMaxEIRP + d.gain(witness.Gateway) - freeSpacePathLoss(distance, witness.Frequency)
+			if float64(witness.Signal) > maxRSSI {
+				addAnomalyEvidence(d.rssi, witness.Gateway, FlagEvidence{
+					Hash:     txHash,
+					Time:     timestamp,
+					Beaconer: path.Challengee,
+					Witness:  witness.Gateway,
+					Distance: int(distance),
+					RSSI:     witness.Signal,
+					Detail:   fmt.Sprintf("max plausible RSSI %.1f dBm", maxRSSI),
+				})
+			}
+		}
+
+		for ts, gateways := range witnessTimestamps {
+
+			if len(gateways) < 2 {
+				continue
+			}
+
+			for _, gateway := range gateways {
+				addAnomalyEvidence(d.timestamps, gateway, FlagEvidence{
+					Hash:     txHash,
+					Time:     timestamp,
+					Beaconer: path.Challengee,
+					Witness:  gateway,
+					Detail:   fmt.Sprintf("%v witnesses reported timestamp %v", len(gateways), ts),
+				})
+			}
+		}
+	}
+}
+
+// flags returns the flags of one hotspot found in the receipts
+func (d *anomalyDetector) flags(address string) []HotspotFlag {
+
+	flags := make([]HotspotFlag, 0)
+
+	if e := d.rssi[address]; e != nil && e.count >= anomalyMinOccurrences {
+		flags = append(flags, newHotspotFlag(flagImplausibleRSSI, fmt.Sprintf("%v witnesses stronger than physically possible at their distance", e.count), e.count, e.evidence))
+	}
+
+	if e := d.timestamps[address]; e != nil && e.count >= anomalyMinOccurrences {
+		flags = append(flags, newHotspotFlag(flagIdenticalTimestamps, fmt.Sprintf("%v witnesses with a timestamp identical to another witness", e.count), e.count, e.evidence))
+	}
+
+	peers := d.relations[address]
+	owner := d.owners[address]
+	if owner != "" && len(peers) >= anomalyClusterMinRelations {
+
+		sameOwner := 0
+		evidence := make([]FlagEvidence, 0)
+		for peer := range peers {
+			if d.owners[peer] == owner {
+				sameOwner++
+				if len(evidence) < anomalyMaxEvidence {
+					evidence = append(evidence, FlagEvidence{Beaconer: address, Witness: peer, Detail: "same owner " + owner})
+				}
+			}
+		}
+
+		if float64(sameOwner)/float64(len(peers)) >= anomalyClusterOwnerShare {
+			flags = append(flags, newHotspotFlag(flagOwnerCluster, fmt.Sprintf("%v of %v witness relations are with hotspots of the same owner", sameOwner, len(peers)), sameOwner, evidence))
+		}
+	}
+
+	return flags
+}
+
+func newHotspotFlag(flagType string, description string, count int, evidence []FlagEvidence) HotspotFlag {
+
+	flag := HotspotFlag{Type: flagType, Description: description, Count: count, Evidence: evidence}
+	if len(flag.Evidence) > anomalyMaxEvidence {
+		flag.Evidence = flag.Evidence[:anomalyMaxEvidence]
+	}
+
+	return flag
+}
+
+// relocationFlag checks the location history for frequent moves
+func relocationFlag(address string) (HotspotFlag, bool) {
+
+	history := getHotspotLocationHistory(address)
+	since := time.Now().AddDate(0, 0, -anomalyRelocationDays).Unix()
+
+	evidence := make([]FlagEvidence, 0)
+	for _, assertion := range history.Assertions {
+		if assertion.Moved && assertion.Time >= since {
+			evidence = append(evidence, FlagEvidence{
+				Hash:     assertion.Hash,
+				Time:     assertion.Time,
+				Distance: assertion.Distance,
+				Detail:   "moved to " + assertion.Place,
+			})
+		}
+	}
+
+	if len(evidence) < anomalyRelocationMoves {
+		return HotspotFlag{}, false
+	}
+
+	return newHotspotFlag(flagRelocations, fmt.Sprintf("%v moves in the last %v days", len(evidence), anomalyRelocationDays), len(evidence), evidence), true
+}
+
+func GetHotspotFlags(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	days := 7
+	if c.QueryParam("days") != "" {
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > pocMaxDays() {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", pocMaxDays()))
+		}
+		days = v
+	}
+
+	flags := getHotspotFlags(hash, days)
+
+	return c.JSON(200, flags)
+}
+
+func getHotspotFlags(address string, days int) HotspotFlags {
+
+	var response HotspotFlags
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-flags-%v-%v", address, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			response = HotspotFlags{address, days, make([]HotspotFlag, 0), time.Now().Unix()}
+
+			// Receipts where the hotspot beaconed, and those where it witnessed
+			rows, err := db.DB.Query(`SELECT DISTINCT
+										t.hash,
+										t.time,
+										t.fields
+									FROM
+										transaction_actors ta
+										INNER JOIN transactions t ON ta.transaction_hash = t.hash
+									WHERE
+										ta.actor = $1
+										AND ta.actor_role IN ('challengee', 'witness')
+										AND t.time >= $2`, address, time.Now().AddDate(0, 0, -days).Unix())
+			if err != nil {
+				log.Printf("[ERROR getHotspotFlags] %v", err)
+				return response
+			}
+
+			defer rows.Close()
+
+			// Only the flags of this hotspot are returned, its gain is the one needed
+			detector := newAnomalyDetector(getAntennaGains([]string{address}))
+
+			var txHash, fields sql.NullString
+			var timestamp sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&txHash, &timestamp, &fields)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				receipt := new(WitnessStruct)
+				json.Unmarshal([]byte(fields.String), &receipt)
+
+				detector.addReceipt(txHash.String, timestamp.Int64, receipt)
+			}
+			rows.Close()
+
+			response.Flags = append(response.Flags, detector.flags(address)...)
+
+			if flag, ok := relocationFlag(address); ok {
+				response.Flags = append(response.Flags, flag)
+			}
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// The network report is rebuilt in the background and kept in memory
+type anomalyReport struct {
+	sync.RWMutex
+	report AnomalyReport
+}
+
+var anomalies = &anomalyReport{}
+
+// StartAnomalyJob scans the PoC receipts of the last ANOMALY_WINDOW_HOURS
+// (default 24) every ANOMALY_REFRESH_MINUTES (default 360)
+func StartAnomalyJob() {
+
+	interval := envInt("ANOMALY_REFRESH_MINUTES", 360)
+	window := envInt("ANOMALY_WINDOW_HOURS", 24)
+
+	go func() {
+		for {
+			computeAnomalyReport(window)
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+func computeAnomalyReport(window int) {
+
+	start := time.Now()
+
+	rows, err := db.DB.Query(`SELECT hash, time, fields FROM transactions WHERE type LIKE 'poc_receipts%' AND time >= $1`, start.Add(-time.Duration(window)*time.Hour).Unix())
+	if err != nil {
+		log.Printf("[ERROR computeAnomalyReport] %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	detector := newAnomalyDetector(getAntennaGains(nil))
+
+	var txHash, fields sql.NullString
+	var timestamp sql.NullInt64
+
+	for rows.Next() {
+
+		err := rows.Scan(&txHash, &timestamp, &fields)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+
+		receipt := new(WitnessStruct)
+		json.Unmarshal([]byte(fields.String), &receipt)
+
+		detector.addReceipt(txHash.String, timestamp.Int64, receipt)
+	}
+	rows.Close()
+
+	flagged := make(map[string][]HotspotFlag, 0)
+	for address := range detector.relations {
+		if flags := detector.flags(address); len(flags) > 0 {
+			flagged[address] = flags
+		}
+	}
+
+	// Relocations, the candidates are confirmed with the location history since
+	// assert_location_v2 can change gain or elevation without a move
+	rows, err = db.DB.Query(`SELECT
+								ta.actor
+							FROM
+								transaction_actors ta
+								INNER JOIN transactions t ON ta.transaction_hash = t.hash
+							WHERE
+								ta.actor_role = 'gateway'
+								AND t.type LIKE 'assert_location%'
+								AND t.time >= $1
+							GROUP BY
+								ta.actor
+							HAVING
+								COUNT(*) >= $2`, start.AddDate(0, 0, -anomalyRelocationDays).Unix(), anomalyRelocationMoves)
+	if err != nil {
+		log.Printf("[ERROR computeAnomalyReport] %v", err)
+	} else {
+
+		candidates := make([]string, 0)
+		var actor sql.NullString
+
+		for rows.Next() {
+			if err := rows.Scan(&actor); err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+			candidates = append(candidates, actor.String)
+		}
+		rows.Close()
+
+		for _, address := range candidates {
+			if flag, ok := relocationFlag(address); ok {
+				flagged[address] = append(flagged[address], flag)
+			}
+		}
+	}
+
+	report := AnomalyReport{
+		Updated:     time.Now().Unix(),
+		WindowHours: window,
+		Counts:      make(map[string]int, len(flagTypes)),
+		Hotspots:    make([]HotspotFlags, 0, len(flagged)),
+	}
+
+	for flagType := range flagTypes {
+		report.Counts[flagType] = 0
+	}
+
+	for address, flags := range flagged {
+		for _, flag := range flags {
+			report.Counts[flag.Type]++
+		}
+		report.Hotspots = append(report.Hotspots, HotspotFlags{address, 0, flags, report.Updated})
+	}
+
+	// Most flagged first
+	sort.Slice(report.Hotspots, func(i, j int) bool {
+		if len(report.Hotspots[i].Flags) == len(report.Hotspots[j].Flags) {
+			return report.Hotspots[i].Address < report.Hotspots[j].Address
+		}
+		return len(report.Hotspots[i].Flags) > len(report.Hotspots[j].Flags)
+	})
+
+	anomalies.Lock()
+	anomalies.report = report
+	anomalies.Unlock()
+
+	log.Printf("[computeAnomalyReport] %v hotspots flagged in %v", len(report.Hotspots), time.Since(start))
+}
+
+func GetAnomalyReport(c echo.Context) error {
+
+	flagType := c.QueryParam("type")
+	if flagType != "" && !flagTypes[flagType] {
+		return c.JSON(400, "Invalid type")
+	}
+
+	page := 0
+	if c.QueryParam("page") != "" {
+		v, err := strconv.Atoi(c.QueryParam("page"))
+		if err != nil || v < 0 {
+			return c.JSON(400, "Invalid page")
+		}
+		page = v
+	}
+
+	limit := 100
+
+	anomalies.RLock()
+	report := anomalies.report
+	anomalies.RUnlock()
+
+	hotspots := make([]HotspotFlags, 0)
+	for _, hotspot := range report.Hotspots {
+
+		if flagType == "" {
+			hotspots = append(hotspots, hotspot)
+			continue
+		}
+
+		for _, flag := range hotspot.Flags {
+			if flag.Type == flagType {
+				hotspots = append(hotspots, hotspot)
+				break
+			}
+		}
+	}
+
+	report.Total = len(hotspots)
+
+	if page*limit >= len(hotspots) {
+		report.Hotspots = make([]HotspotFlags, 0)
+	} else {
+		report.Hotspots = hotspots[page*limit : int(math.Min(float64((page+1)*limit), float64(len(hotspots))))]
+	}
+
+	return c.JSON(200, report)
+}
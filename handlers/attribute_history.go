@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+// Attributes tracked by the snapshot job and the gateway_inventory expression they
+// are read from. Gain and elevation only change with assert_location transactions,
+// their history comes from the chain.
+var snapshotColumns = map[string]string{
+	"mode":         "gi.mode::TEXT",
+	"reward_scale": "ROUND(gi.reward_scale::NUMERIC, 4)::TEXT",
+}
+
+var historyAttributes = map[string]bool{
+	"gain":         true,
+	"elevation":    true,
+	"mode":         true,
+	"reward_scale": true,
+}
+
+// StartAttributeSnapshotJob compares gateway_inventory with the last snapshot
+// every ATTRIBUTE_SNAPSHOT_MINUTES (default 60) and records the changes
+func StartAttributeSnapshotJob() {
+
+	_, err := db.DB.Exec(`CREATE TABLE IF NOT EXISTS hotspot_attribute_snapshots (
+							address TEXT PRIMARY KEY,
+							mode TEXT,
+							reward_scale TEXT,
+							updated_at BIGINT
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartAttributeSnapshotJob] %v", err)
+		return
+	}
+
+	_, err = db.DB.Exec(`CREATE TABLE IF NOT EXISTS hotspot_attribute_history (
+							address TEXT NOT NULL,
+							attribute TEXT NOT NULL,
+							old_value TEXT,
+							new_value TEXT,
+							changed_at BIGINT NOT NULL
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartAttributeSnapshotJob] %v", err)
+		return
+	}
+
+	_, err = db.DB.Exec(`CREATE INDEX IF NOT EXISTS hotspot_attribute_history_address_idx ON hotspot_attribute_history (address, changed_at)`)
+	if err != nil {
+		log.Printf("[ERROR StartAttributeSnapshotJob] %v", err)
+	}
+
+	interval := envInt("ATTRIBUTE_SNAPSHOT_MINUTES", 60)
+
+	go func() {
+		for {
+			snapshotHotspotAttributes()
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+// snapshotHotspotAttributes records the attributes that changed since the last
+// snapshot, then stores the new snapshot. The first run only stores the snapshot.
+func snapshotHotspotAttributes() {
+
+	start := time.Now()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("[ERROR snapshotHotspotAttributes] %v", err)
+		return
+	}
+
+	// columns come from snapshotColumns, never from user input
+	for attribute, column := range snapshotColumns {
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO hotspot_attribute_history
+										SELECT
+											gi.address,
+											$1,
+											s.%[1]v,
+											%[2]v,
+											$2
+										FROM
+											gateway_inventory gi
+											INNER JOIN hotspot_attribute_snapshots s ON s.address = gi.address
+										WHERE
+											s.%[1]v IS DISTINCT FROM %[2]v`, attribute, column), attribute, start.Unix())
+		if err != nil {
+			log.Printf("[ERROR snapshotHotspotAttributes] %v", err)
+			tx.Rollback()
+			return
+		}
+	}
+
+	// Only new hotspots and changed rows are written, updated_at is the last change
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO hotspot_attribute_snapshots
+									SELECT
+										gi.address,
+										%[1]v,
+										%[2]v,
+										$1
+									FROM
+										gateway_inventory gi
+										LEFT JOIN hotspot_attribute_snapshots s ON s.address = gi.address
+									WHERE
+										s.address IS NULL
+										OR s.mode IS DISTINCT FROM %[1]v
+										OR s.reward_scale IS DISTINCT FROM %[2]v
+								ON CONFLICT (address) DO UPDATE SET
+									mode = EXCLUDED.mode,
+									reward_scale = EXCLUDED.reward_scale,
+									updated_at = EXCLUDED.updated_at`, snapshotColumns["mode"], snapshotColumns["reward_scale"]), start.Unix())
+	if err != nil {
+		log.Printf("[ERROR snapshotHotspotAttributes] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[ERROR snapshotHotspotAttributes] %v", err)
+		return
+	}
+
+	log.Printf("Hotspot attribute snapshot taken in %v", time.Since(start))
+}
+
+func GetHotspotAttributeHistory(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	attribute := c.QueryParam("attribute")
+	if attribute != "" && !historyAttributes[attribute] {
+		return c.JSON(400, "Invalid attribute")
+	}
+
+	history := getHotspotAttributeHistory(hash)
+
+	if attribute != "" {
+		changes := make([]AttributeChange, 0)
+		for _, change := range history.Changes {
+			if change.Attribute == attribute {
+				changes = append(changes, change)
+			}
+		}
+		history.Changes = changes
+	}
+
+	return c.JSON(200, history)
+}
+
+// getHotspotAttributeHistory merges the gain and elevation changes of the location
+// assertions with the recorded snapshot changes, newest first
+func getHotspotAttributeHistory(hash string) AttributeHistory {
+
+	history := AttributeHistory{hash, make([]AttributeChange, 0)}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-attribute-history-%v", hash)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			// getHotspotLocationHistory is newest first. assert_location_v1 has no gain
+			// or elevation, the hotspot keeps the defaults (1.2 dBi, 0 m).
+			assertions := getHotspotLocationHistory(hash).Assertions
+			gain, elevation := 12, 0
+			for i := len(assertions) - 1; i >= 0; i-- {
+
+				assertion := assertions[i]
+				if assertion.Type == "assert_location_v1" {
+					continue
+				}
+
+				if assertion.Gain != gain {
+					history.Changes = append(history.Changes, AttributeChange{"gain", strconv.Itoa(gain), strconv.Itoa(assertion.Gain), assertion.Time, assertion.Block, assertion.Hash, assertion.Type})
+					gain = assertion.Gain
+				}
+
+				if assertion.Elevation != elevation {
+					history.Changes = append(history.Changes, AttributeChange{"elevation", strconv.Itoa(elevation), strconv.Itoa(assertion.Elevation), assertion.Time, assertion.Block, assertion.Hash, assertion.Type})
+					elevation = assertion.Elevation
+				}
+			}
+
+			rows, err := db.DB.Query(`SELECT attribute, old_value, new_value, changed_at FROM hotspot_attribute_history WHERE address = $1`, hash)
+			if err != nil {
+				log.Printf("[ERROR getHotspotAttributeHistory] %v", err)
+			} else {
+
+				var attribute, oldValue, newValue sql.NullString
+				var changedAt sql.NullInt64
+
+				for rows.Next() {
+
+					err := rows.Scan(&attribute, &oldValue, &newValue, &changedAt)
+					if err != nil {
+						log.Printf("[ERROR] %v", err)
+					}
+
+					history.Changes = append(history.Changes, AttributeChange{attribute.String, oldValue.String, newValue.String, changedAt.Int64, 0, "", "snapshot"})
+				}
+				rows.Close()
+			}
+
+			sort.SliceStable(history.Changes, func(i, j int) bool {
+				return history.Changes[i].Time > history.Changes[j].Time
+			})
+
+			if err := enc.Encode(history); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&history); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return history
+}
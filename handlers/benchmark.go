@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/uber/h3-go/v3"
+)
+
+// Peer groups, each one selects the peer addresses with $1 as the group key.
+// The benchmarked hotspot is part of its groups.
+var benchmarkPeerGroups = map[string]string{
+	"hex":   `SELECT address FROM gateway_inventory WHERE address = ANY($1)`,
+	"city":  `SELECT h.address FROM gateway_inventory h INNER JOIN locations l ON l.location = h.location WHERE l.city_id = $1`,
+	"maker": `SELECT address FROM gateway_inventory WHERE payer = $1`,
+}
+
+// Resolution of the cached peer distribution, quantiles every 0.1%
+const benchmarkQuantiles = 1000
+
+func GetHotspotBenchmark(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	days := 30
+	if c.QueryParam("days") != "" {
+		maxDays := envInt("BENCHMARK_MAX_DAYS", 90)
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > maxDays {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", maxDays))
+		}
+		days = v
+	}
+
+	benchmark := getHotspotBenchmark(hash, days)
+
+	return c.JSON(200, benchmark)
+}
+
+// getHotspotBenchmark compares the hotspot rewards over the last days with the
+// rewards of the hotspots in the same res-8 hex, the same city and of the same maker
+func getHotspotBenchmark(hash string, days int) HotspotBenchmark {
+
+	var response HotspotBenchmark
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-benchmark-%v-%v", hash, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			// The hotspot and its peer groups share the same window, aligned to the
+			// hour so the group distributions can be cached
+			now := time.Now().Unix()
+			to := time.Unix(now-now%3600, 0)
+			from := to.AddDate(0, 0, -days)
+
+			// Without the density model the hex peers are unknown, don't cache that
+			ready := densityReady()
+
+			rewards := getRewardSeries("gateway", hash, from, to, "day", time.UTC)
+			total := rewards.Total
+
+			response = HotspotBenchmark{
+				Address: hash,
+				Days:    days,
+				Rewards: total,
+				Series:  newTimeSeriesInt(rewards.Rewards, "day", "bones"),
+				Peers:   make(map[string]PeerBenchmark, 0),
+				Updated: time.Now().Unix(),
+			}
+
+			hotspotData := getHotspotData(hash)
+			if len(hotspotData) == 0 || hotspotData[0].Address == "" {
+				return response
+			}
+
+			if hotspotData[0].Location != "" {
+
+				if ready {
+					hexPeers := make([]string, 0)
+					res8 := h3.ToParent(h3.FromString(hotspotData[0].Location), 8)
+					for _, peer := range hotspotsInRes8(res8) {
+						hexPeers = append(hexPeers, peer.Address)
+					}
+					response.Peers["hex"] = getPeerBenchmark("hex", h3.ToString(res8), h3.ToString(res8), pq.Array(hexPeers), from, to, total)
+				}
+
+				geolocation := getGeolocationData(hotspotData[0].Location)
+				if geolocation.CityID != "" {
+					response.Peers["city"] = getPeerBenchmark("city", geolocation.CityID, geolocation.CityID, geolocation.CityID, from, to, total)
+				}
+			}
+
+			if hotspotData[0].Payer != "" {
+				response.Peers["maker"] = getPeerBenchmark("maker", hotspotData[0].Maker, hotspotData[0].Payer, hotspotData[0].Payer, from, to, total)
+			}
+
+			if ready {
+				if err := enc.Encode(response); err != nil {
+					log.Println("Error gob: ", err)
+				}
+
+				db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+			}
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// getPeerBenchmark places the hotspot in the reward distribution of a peer group.
+// The distribution is shared by every hotspot of the group and includes the
+// hotspot itself, every statistic is computed over that same set.
+func getPeerBenchmark(group string, name string, id string, key interface{}, from time.Time, to time.Time, total int64) PeerBenchmark {
+
+	benchmark := PeerBenchmark{Group: name, Percentiles: make(map[string]float64, 0)}
+
+	distribution, ok := getPeerDistribution(group, id, key, from, to)
+	if !ok || distribution.Count == 0 {
+		return benchmark
+	}
+
+	count := float64(distribution.Count)
+	mean := distribution.Sum / count
+	stddev := math.Sqrt(math.Max(distribution.Squares/count-mean*mean, 0))
+
+	quantile := func(q float64) float64 {
+		return distribution.Quantiles[int(math.Round(q*benchmarkQuantiles))]
+	}
+
+	// Peers are the other hotspots of the group
+	benchmark.Peers = int(distribution.Count) - 1
+	benchmark.Mean = mean
+	benchmark.Median = quantile(0.5)
+	benchmark.Percentiles = map[string]float64{
+		"p10": quantile(0.1),
+		"p25": quantile(0.25),
+		"p50": quantile(0.5),
+		"p75": quantile(0.75),
+		"p90": quantile(0.9),
+	}
+
+	below := sort.SearchFloat64s(distribution.Quantiles, float64(total))
+	benchmark.PercentileRank = math.Round(float64(below)/float64(len(distribution.Quantiles))*1000) / 10
+
+	if stddev > 0 {
+		benchmark.ZScore = (float64(total) - mean) / stddev
+	}
+
+	return benchmark
+}
+
+// peerDistribution sums up the rewards of a peer group, peers without rewards
+// counting as zero
+type peerDistribution struct {
+	Count     int64
+	Sum       float64
+	Squares   float64
+	Quantiles []float64
+}
+
+// getPeerDistribution is cached per group, the "maker" group spans whole fleets
+func getPeerDistribution(group string, id string, key interface{}, from time.Time, to time.Time) (peerDistribution, bool) {
+
+	var distribution peerDistribution
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	hashed := sha1.Sum([]byte(id))
+	cacheName := fmt.Sprintf("peer-distribution-%v-%v-%v-%v", group, hex.EncodeToString(hashed[:]), from.Unix(), to.Unix())
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			quantiles := make([]float64, 0, benchmarkQuantiles+1)
+			for i := 0; i <= benchmarkQuantiles; i++ {
+				quantiles = append(quantiles, float64(i)/benchmarkQuantiles)
+			}
+
+			// group queries come from benchmarkPeerGroups, never from user input
+			row := db.DB.QueryRow(`WITH peers AS (`+benchmarkPeerGroups[group]+`),
+									totals AS (
+										SELECT
+											p.address,
+											COALESCE(SUM(r.amount), 0)::DOUBLE PRECISION AS total
+										FROM
+											peers p
+											LEFT JOIN rewards r ON r.gateway = p.address AND r.time >= $2 AND r.time < $3
+										GROUP BY
+											p.address
+									)
+									SELECT
+										COUNT(*),
+										COALESCE(SUM(total), 0),
+										COALESCE(SUM(total * total), 0),
+										percentile_cont($4::DOUBLE PRECISION[]) WITHIN GROUP (ORDER BY total)
+									FROM
+										totals`, key, from.Unix(), to.Unix(), pq.Array(quantiles))
+
+			var count sql.NullInt64
+			var sum, squares sql.NullFloat64
+			values := make([]float64, 0)
+
+			err := row.Scan(&count, &sum, &squares, pq.Array(&values))
+			if err != nil {
+				log.Printf("[ERROR getPeerDistribution] %v", err)
+				return distribution, false
+			}
+
+			distribution = peerDistribution{count.Int64, sum.Float64, squares.Float64, values}
+			if len(distribution.Quantiles) != benchmarkQuantiles+1 {
+				distribution.Count = 0
+			}
+
+			if err := enc.Encode(distribution); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&distribution); err != nil {
+			log.Println("Error decode: ", err)
+			return distribution, false
+		}
+	}
+
+	return distribution, true
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// compareMaxHotspots is the largest list accepted by POST /hotspots/compare/,
+// COMPARE_MAX_HOTSPOTS (default 10)
+func compareMaxHotspots() int {
+
+	return envInt("COMPARE_MAX_HOTSPOTS", 10)
+}
+
+func CompareHotspots(c echo.Context) error {
+
+	type payload struct {
+		HotspotIDs []string `json:"hotspots"`
+		Days       int      `json:"days"`
+	}
+
+	res := new(payload)
+	if err := c.Bind(res); err != nil {
+		log.Printf("%v", err)
+		return err
+	}
+
+	hotspots := uniqueHotspotIDs(res.HotspotIDs)
+
+	if len(hotspots) == 0 {
+		return c.JSON(400, "Bad request")
+	}
+
+	if len(hotspots) > compareMaxHotspots() {
+		return c.JSON(400, fmt.Sprintf("Too many hotspots, the maximum is %v", compareMaxHotspots()))
+	}
+
+	if res.Days == 0 {
+		res.Days = 7
+	}
+
+	if res.Days < 1 || res.Days > pocMaxDays() {
+		return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", pocMaxDays()))
+	}
+
+	comparison := compareHotspots(hotspots, res.Days)
+
+	return c.JSON(200, comparison)
+}
+
+// compareHotspots returns the daily series of each hotspot over the same UTC
+// days, the last bucket being today
+func compareHotspots(hotspots []string, days int) HotspotComparison {
+
+	// Round down to the minute so the reward series can be cached
+	to := time.Unix(time.Now().Unix()-time.Now().Unix()%60, 0)
+	today := to.UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -(days - 1))
+
+	comparison := HotspotComparison{
+		Days:     days,
+		Buckets:  make([]int64, 0, days),
+		Hotspots: make([]ComparedHotspot, 0, len(hotspots)),
+	}
+
+	for d := from; !d.After(today); d = d.AddDate(0, 0, 1) {
+		comparison.Buckets = append(comparison.Buckets, d.Unix())
+	}
+
+	statuses := getHotspotStatuses(hotspots)
+
+	for _, hash := range hotspots {
+
+		compared := ComparedHotspot{Address: hash, Status: statuses[hash]}
+
+		hotspotData := getHotspotData(hash)
+		if len(hotspotData) == 1 && hotspotData[0].Address != "" {
+			data := hotspotData[0]
+			compared.Found = true
+			compared.Name = data.Name
+			compared.Place = data.Place
+			compared.Location = data.Location
+			compared.Gain = data.Gain
+			compared.Elevation = data.Elevation
+			compared.Maker = data.Maker
+			compared.RewardScale = data.Reward_scale
+			compared.Mode = data.Mode
+		}
+
+		rewards := getRewardSeries("gateway", hash, from, to, "day", time.UTC)
+		compared.Rewards = newTimeSeriesInt(rewards.Rewards, "day", "bones")
+
+		activity := getHotspotPocActivity(hash, days)
+		compared.Beacons = activity.BeaconsSent
+		compared.WitnessesValid = activity.WitnessesValid
+		compared.WitnessesInvalid = activity.WitnessesInvalid
+
+		comparison.Hotspots = append(comparison.Hotspots, compared)
+	}
+
+	return comparison
+}
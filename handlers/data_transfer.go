@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+// Levels of the data leaderboards: hotspots (summary clients) and routers
+// (state channel owners)
+var dataLeaderboardLevels = map[string]bool{
+	"hotspots": true,
+	"routers":  true,
+}
+
+// Sort options and the data_leaderboards column they order by
+var dataLeaderboardSorts = map[string]string{
+	"packets": "packets",
+	"dcs":     "dcs",
+}
+
+func GetHotspotDataTransfer(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	days := 30
+	if c.QueryParam("days") != "" {
+		maxDays := envInt("DATA_TRANSFER_MAX_DAYS", 90)
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > maxDays {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", maxDays))
+		}
+		days = v
+	}
+
+	data := getHotspotDataTransfer(hash, days)
+
+	return c.JSON(200, data)
+}
+
+// getHotspotDataTransfer sums the state channel summaries of the hotspot per UTC
+// day and per state channel owner
+func getHotspotDataTransfer(address string, days int) HotspotDataTransfer {
+
+	var response HotspotDataTransfer
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-data-transfer-%v-%v", address, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			today := time.Now().UTC().Truncate(24 * time.Hour)
+			startDate := today.AddDate(0, 0, -(days - 1))
+
+			packets := emptyDaySeries(startDate, today)
+			dcs := emptyDaySeries(startDate, today)
+			routers := make(map[string]*RouterTraffic, 0)
+
+			response = HotspotDataTransfer{Address: address, Days: days, Routers: make([]RouterTraffic, 0)}
+
+			rows, err := db.DB.Query(`SELECT
+										t.time,
+										t.fields
+									FROM
+										transaction_actors ta
+										INNER JOIN transactions t ON ta.transaction_hash = t.hash
+									WHERE
+										ta.actor = $1
+										AND ta.actor_role = 'packet_receiver'
+										AND t.time >= $2`, address, startDate.Unix())
+			if err != nil {
+				log.Printf("[ERROR getHotspotDataTransfer] %v", err)
+				return response
+			}
+
+			defer rows.Close()
+
+			var fields sql.NullString
+			var timestamp sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&timestamp, &fields)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+					continue
+				}
+
+				dataPacket := new(DataPacket)
+				if err := json.Unmarshal([]byte(fields.String), &dataPacket); err != nil {
+					log.Printf("[ERROR getHotspotDataTransfer] %v", err)
+					continue
+				}
+
+				day := time.Unix(timestamp.Int64, 0).UTC().Truncate(24 * time.Hour).Unix()
+				owner := dataPacket.StateChannel.Owner
+
+				for _, summary := range dataPacket.StateChannel.Summaries {
+
+					if summary.Client != address {
+						continue
+					}
+
+					packets[day] += int64(summary.NumPackets)
+					dcs[day] += int64(summary.NumDcs)
+
+					router, ok := routers[owner]
+					if !ok {
+						router = &RouterTraffic{Owner: owner}
+						routers[owner] = router
+					}
+
+					router.Packets += int64(summary.NumPackets)
+					router.DCs += int64(summary.NumDcs)
+					router.StateChannels++
+				}
+			}
+			rows.Close()
+
+			response.Packets = newTimeSeriesInt(packets, "day", "packets")
+			response.DCs = newTimeSeriesInt(dcs, "day", "dc")
+			response.TotalPackets = int64(response.Packets.Sum)
+			response.TotalDCs = int64(response.DCs.Sum)
+
+			for _, router := range routers {
+				response.Routers = append(response.Routers, *router)
+			}
+
+			sort.Slice(response.Routers, func(i, j int) bool {
+				return response.Routers[i].Packets > response.Routers[j].Packets
+			})
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// dataLeaderboardSize is the number of entries ranked per sort, DATA_LEADERBOARD_SIZE
+// (default 1000)
+func dataLeaderboardSize() int {
+
+	return envInt("DATA_LEADERBOARD_SIZE", 1000)
+}
+
+// StartDataLeaderboardJob creates the data_leaderboards table and recomputes it
+// every DATA_LEADERBOARD_REFRESH_MINUTES (default 60). Only the top
+// DATA_LEADERBOARD_SIZE (default 1000) entries per sort are kept.
+func StartDataLeaderboardJob() {
+
+	_, err := db.DB.Exec(`CREATE TABLE IF NOT EXISTS data_leaderboards (
+							level TEXT NOT NULL,
+							id TEXT NOT NULL,
+							period INTEGER NOT NULL,
+							packets BIGINT,
+							dcs BIGINT,
+							state_channels BIGINT,
+							updated_at BIGINT,
+							PRIMARY KEY (level, period, id)
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartDataLeaderboardJob] %v", err)
+		return
+	}
+
+	interval := envInt("DATA_LEADERBOARD_REFRESH_MINUTES", 60)
+
+	go func() {
+		for {
+			computeDataLeaderboards(dataLeaderboardSize())
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+// computeDataLeaderboards reads the state channel closes of the longest period
+// once and sums them for every period
+func computeDataLeaderboards(size int) {
+
+	start := time.Now()
+
+	longest := 0
+	for _, period := range leaderboardPeriods {
+		if period > longest {
+			longest = period
+		}
+	}
+
+	// totals[level][period][id]
+	totals := make(map[string]map[int]map[string]*RouterTraffic, 0)
+	for level := range dataLeaderboardLevels {
+		totals[level] = make(map[int]map[string]*RouterTraffic, 0)
+		for _, period := range leaderboardPeriods {
+			totals[level][period] = make(map[string]*RouterTraffic, 0)
+		}
+	}
+
+	add := func(level string, period int, id string, packets int64, dcs int64) {
+		entry, ok := totals[level][period][id]
+		if !ok {
+			entry = &RouterTraffic{Owner: id}
+			totals[level][period][id] = entry
+		}
+		entry.Packets += packets
+		entry.DCs += dcs
+		entry.StateChannels++
+	}
+
+	rows, err := db.DB.Query(`SELECT time, fields FROM transactions WHERE type = 'state_channel_close_v1' AND time >= $1`, start.AddDate(0, 0, -longest).Unix())
+	if err != nil {
+		log.Printf("[ERROR computeDataLeaderboards] %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	var fields sql.NullString
+	var timestamp sql.NullInt64
+
+	for rows.Next() {
+
+		err := rows.Scan(&timestamp, &fields)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+
+		dataPacket := new(DataPacket)
+		if err := json.Unmarshal([]byte(fields.String), &dataPacket); err != nil {
+			log.Printf("[ERROR computeDataLeaderboards] %v", err)
+			continue
+		}
+
+		for _, period := range leaderboardPeriods {
+
+			if timestamp.Int64 < start.AddDate(0, 0, -period).Unix() {
+				continue
+			}
+
+			var channelPackets, channelDCs int64
+			for _, summary := range dataPacket.StateChannel.Summaries {
+				add("hotspots", period, summary.Client, int64(summary.NumPackets), int64(summary.NumDcs))
+				channelPackets += int64(summary.NumPackets)
+				channelDCs += int64(summary.NumDcs)
+			}
+
+			add("routers", period, dataPacket.StateChannel.Owner, channelPackets, channelDCs)
+		}
+	}
+	rows.Close()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("[ERROR computeDataLeaderboards] %v", err)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM data_leaderboards`)
+	if err != nil {
+		log.Printf("[ERROR computeDataLeaderboards] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO data_leaderboards (level, id, period, packets, dcs, state_channels, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`)
+	if err != nil {
+		log.Printf("[ERROR computeDataLeaderboards] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	for level, periods := range totals {
+		for period, entries := range periods {
+
+			list := make([]*RouterTraffic, 0, len(entries))
+			for _, entry := range entries {
+				list = append(list, entry)
+			}
+
+			// Keep the top entries by packets and by DCs
+			keep := make(map[string]*RouterTraffic, 0)
+
+			sort.Slice(list, func(i, j int) bool { return list[i].Packets > list[j].Packets })
+			for i := 0; i < len(list) && i < size; i++ {
+				keep[list[i].Owner] = list[i]
+			}
+
+			sort.Slice(list, func(i, j int) bool { return list[i].DCs > list[j].DCs })
+			for i := 0; i < len(list) && i < size; i++ {
+				keep[list[i].Owner] = list[i]
+			}
+
+			for id, entry := range keep {
+				_, err := stmt.Exec(level, id, period, entry.Packets, entry.DCs, entry.StateChannels, start.Unix())
+				if err != nil {
+					log.Printf("[ERROR computeDataLeaderboards] %v", err)
+					stmt.Close()
+					tx.Rollback()
+					return
+				}
+			}
+		}
+	}
+
+	stmt.Close()
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[ERROR computeDataLeaderboards] %v", err)
+		return
+	}
+
+	log.Printf("Data leaderboards computed in %v", time.Since(start))
+}
+
+func GetDataLeaderboard(c echo.Context) error {
+
+	level := c.Param("level")
+	if !dataLeaderboardLevels[level] {
+		return c.JSON(400, "Bad request")
+	}
+
+	sortBy := c.QueryParam("sort")
+	if sortBy == "" {
+		sortBy = "packets"
+	}
+
+	if _, ok := dataLeaderboardSorts[sortBy]; !ok {
+		return c.JSON(400, "Bad request")
+	}
+
+	period := 30
+	if c.QueryParam("period") != "" {
+
+		p, err := strconv.Atoi(c.QueryParam("period"))
+		if err != nil {
+			return c.JSON(400, "Bad request")
+		}
+
+		valid := false
+		for _, v := range leaderboardPeriods {
+			if v == p {
+				valid = true
+			}
+		}
+
+		if !valid {
+			return c.JSON(400, "Bad request")
+		}
+
+		period = p
+	}
+
+	page := c.QueryParam("page")
+
+	if page == "" {
+		page = "0"
+	}
+
+	offset, err := strconv.Atoi(page)
+	if err != nil {
+		log.Println(err)
+	}
+
+	limit := 25
+	offset = offset * limit
+
+	// The table holds the top entries of both sorts, past dataLeaderboardSize the
+	// rows are only there because of the other sort
+	size := dataLeaderboardSize()
+	if offset < 0 || offset >= size {
+		return c.JSON(200, make([]DataLeaderboard, 0))
+	}
+
+	if offset+limit > size {
+		limit = size - offset
+	}
+
+	leaderboard := getDataLeaderboard(level, sortBy, period, limit, offset)
+
+	return c.JSON(200, leaderboard)
+}
+
+func getDataLeaderboard(level string, sortBy string, period int, limit int, offset int) []DataLeaderboard {
+
+	leaderboard := make([]DataLeaderboard, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("data-leaderboard-%v-%v-%v-%v-%v", level, sortBy, period, offset, limit)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			// sort column comes from dataLeaderboardSorts, never from user input
+			rows, err := db.DB.Query(fmt.Sprintf(`SELECT
+													id,
+													packets,
+													dcs,
+													state_channels,
+													updated_at
+												FROM
+													data_leaderboards
+												WHERE
+													level = $1
+													AND period = $2
+												ORDER BY
+													%v DESC, id
+												LIMIT $3 OFFSET $4`, dataLeaderboardSorts[sortBy]), level, period, limit, offset)
+			if err != nil {
+				log.Printf("[ERROR getDataLeaderboard] %v", err)
+				return leaderboard
+			}
+
+			defer rows.Close()
+
+			var id sql.NullString
+			var packets, dcs, stateChannels, updatedAt sql.NullInt64
+
+			rank := offset
+			for rows.Next() {
+
+				err := rows.Scan(&id, &packets, &dcs, &stateChannels, &updatedAt)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				rank++
+
+				leaderboard = append(leaderboard, DataLeaderboard{
+					rank,
+					id.String,
+					period,
+					packets.Int64,
+					dcs.Int64,
+					stateChannels.Int64,
+					updatedAt.Int64,
+				})
+			}
+			rows.Close()
+
+			if err := enc.Encode(leaderboard); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 300})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&leaderboard); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return leaderboard
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"database/sql"
+	"hntscan/db"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/uber/h3-go/v3"
+)
+
+// HIP17 hex density parameters per H3 resolution
+type densityParams struct {
+	N      int
+	Target int
+	Max    int
+}
+
+var densityResolutions = map[int]densityParams{
+	4:  {1, 250, 800},
+	5:  {1, 100, 400},
+	6:  {1, 25, 100},
+	7:  {2, 5, 20},
+	8:  {2, 1, 4},
+	9:  {2, 1, 2},
+	10: {2, 1, 1},
+}
+
+const densityMinRes = 4
+const densityMaxRes = 10
+
+type indexedHotspot struct {
+	Address     string
+	Name        string
+	Owner       string
+	Payer       string
+	Location    string
+	Index       h3.H3Index
+	RewardScale float64
+}
+
+// densityModel keeps every asserted hotspot in memory, grouped per res-8 hex,
+// together with the unclipped and clipped HIP17 densities per resolution.
+type densityModel struct {
+	sync.RWMutex
+	hotspots  []indexedHotspot
+	byRes8    map[h3.H3Index][]int
+	unclipped map[int]map[h3.H3Index]int
+	clipped   map[int]map[h3.H3Index]int
+	updated   int64
+}
+
+var density = &densityModel{}
+
+// StartDensityModel loads the hotspot index and refreshes it on an interval
+// (DENSITY_REFRESH_MINUTES, defaults to 30 minutes)
+func StartDensityModel() {
+
+	interval := envInt("DENSITY_REFRESH_MINUTES", 30)
+
+	go func() {
+		for {
+			loadDensityModel()
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+func loadDensityModel() {
+
+	start := time.Now()
+
+	rows, err := db.DB.Query(`SELECT address, name, owner, payer, location, reward_scale FROM gateway_inventory WHERE location IS NOT NULL`)
+	if err != nil {
+		log.Printf("[ERROR loadDensityModel] %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	var address, name, owner, payer, location sql.NullString
+	var rewardScale sql.NullFloat64
+
+	hotspots := make([]indexedHotspot, 0)
+	byRes8 := make(map[h3.H3Index][]int, 0)
+	raw := make(map[h3.H3Index]int, 0)
+
+	for rows.Next() {
+
+		err := rows.Scan(&address, &name, &owner, &payer, &location, &rewardScale)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+
+		index := h3.FromString(location.String)
+		if !h3.IsValid(index) || h3.Resolution(index) < densityMaxRes {
+			continue
+		}
+
+		res8 := h3.ToParent(index, 8)
+		byRes8[res8] = append(byRes8[res8], len(hotspots))
+		raw[h3.ToParent(index, densityMaxRes)]++
+
+		hotspots = append(hotspots, indexedHotspot{address.String, name.String, owner.String, payer.String, location.String, index, rewardScale.Float64})
+	}
+	rows.Close()
+
+	unclipped, clipped := computeDensities(raw)
+
+	density.Lock()
+	density.hotspots = hotspots
+	density.byRes8 = byRes8
+	density.unclipped = unclipped
+	density.clipped = clipped
+	density.updated = time.Now().Unix()
+	density.Unlock()
+
+	log.Printf("Density model loaded %v hotspots in %v", len(hotspots), time.Since(start))
+}
+
+// computeDensities walks from res 10 up to res 4. The unclipped density of a hex
+// is the sum of the clipped densities of its children, and it gets clipped to a
+// limit that depends on how many neighbouring hexes reach the target density.
+func computeDensities(raw map[h3.H3Index]int) (map[int]map[h3.H3Index]int, map[int]map[h3.H3Index]int) {
+
+	unclipped := make(map[int]map[h3.H3Index]int, 0)
+	clipped := make(map[int]map[h3.H3Index]int, 0)
+
+	unclipped[densityMaxRes] = raw
+
+	for res := densityMaxRes; res >= densityMinRes; res-- {
+
+		params := densityResolutions[res]
+		clipped[res] = make(map[h3.H3Index]int, len(unclipped[res]))
+
+		for hex, value := range unclipped[res] {
+			occupied := occupiedNeighbours(unclipped[res], hex, params.Target, 0)
+			clipped[res][hex] = clipDensity(value, occupied, params)
+		}
+
+		if res > densityMinRes {
+			parents := make(map[h3.H3Index]int, 0)
+			for hex, value := range clipped[res] {
+				parents[h3.ToParent(hex, res-1)] += value
+			}
+			unclipped[res-1] = parents
+		}
+	}
+
+	return unclipped, clipped
+}
+
+// occupiedNeighbours counts the hexes in the 1-ring (including itself) that reach
+// the target density. delta is added to the origin hex for what-if calculations.
+func occupiedNeighbours(values map[h3.H3Index]int, origin h3.H3Index, target int, delta int) int {
+
+	occupied := 0
+	for _, neighbour := range h3.KRing(origin, 1) {
+		value := values[neighbour]
+		if neighbour == origin {
+			value += delta
+		}
+		if value >= target {
+			occupied++
+		}
+	}
+
+	return occupied
+}
+
+func clipDensity(value int, occupied int, params densityParams) int {
+
+	multiplier := occupied - params.N + 1
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	limit := params.Target * multiplier
+	if limit > params.Max {
+		limit = params.Max
+	}
+
+	if value > limit {
+		return limit
+	}
+
+	return value
+}
+
+// simulateRewardScale returns the transmit scale a new hotspot at the given
+// res-10+ index would get, with every existing hotspot left in place.
+func simulateRewardScale(index h3.H3Index) float64 {
+
+	density.RLock()
+	defer density.RUnlock()
+
+	scale := 1.0
+	delta := 1
+
+	for res := densityMaxRes; res >= densityMinRes; res-- {
+
+		params := densityResolutions[res]
+		hex := h3.ToParent(index, res)
+
+		baseUnclipped := density.unclipped[res][hex]
+		baseClipped := density.clipped[res][hex]
+
+		newUnclipped := baseUnclipped + delta
+		occupied := occupiedNeighbours(density.unclipped[res], hex, params.Target, delta)
+		newClipped := clipDensity(newUnclipped, occupied, params)
+
+		if newUnclipped > 0 {
+			scale = scale * float64(newClipped) / float64(newUnclipped)
+		}
+
+		delta = newClipped - baseClipped
+	}
+
+	return scale
+}
+
+// hotspotsWithin returns the indexed hotspots within maxDistance meters of the point
+func hotspotsWithin(lat float64, long float64, maxDistance float64) []indexedHotspot {
+
+	origin := h3.GeoCoord{Latitude: lat, Longitude: long}
+	center := h3.FromGeo(origin, 8)
+
+	// A res-8 hex has an edge of ~461m, so each ring adds roughly 800m
+	rings := int(maxDistance/800) + 1
+
+	density.RLock()
+	defer density.RUnlock()
+
+	result := make([]indexedHotspot, 0)
+
+	for _, hex := range h3.KRing(center, rings) {
+		for _, i := range density.byRes8[hex] {
+			hotspot := density.hotspots[i]
+			if h3.PointDistM(origin, h3.ToGeo(hotspot.Index)) <= maxDistance {
+				result = append(result, hotspot)
+			}
+		}
+	}
+
+	return result
+}
+
+// hotspotsInRes8 returns the indexed hotspots sharing the given res-8 parent hex
+func hotspotsInRes8(hex h3.H3Index) []indexedHotspot {
+
+	density.RLock()
+	defer density.RUnlock()
+
+	result := make([]indexedHotspot, 0, len(density.byRes8[hex]))
+	for _, i := range density.byRes8[hex] {
+		result = append(result, density.hotspots[i])
+	}
+
+	return result
+}
+
+// densityReady tells if the density model has been loaded at least once
+func densityReady() bool {
+
+	density.RLock()
+	defer density.RUnlock()
+
+	return density.updated != 0
+}
+
+// allIndexedHotspots returns a copy of the indexed hotspots
+func allIndexedHotspots() []indexedHotspot {
+
+	density.RLock()
+	defer density.RUnlock()
+
+	result := make([]indexedHotspot, len(density.hotspots))
+	copy(result, density.hotspots)
+
+	return result
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// denylist keeps the addresses of the local denylist file in memory
+type denylistState struct {
+	sync.RWMutex
+	addresses map[string]bool
+	sorted    []string
+	info      DenylistInfo
+}
+
+var denylist = &denylistState{addresses: make(map[string]bool, 0)}
+
+// StartDenylist loads DENYLIST_FILE (default ./denylist.txt) and reloads it every
+// DENYLIST_RELOAD_MINUTES (default 10). The file has one address per line, "#"
+// starts a comment and "# key: value" header lines are kept as metadata, "version"
+// being the denylist version.
+func StartDenylist() {
+
+	file := os.Getenv("DENYLIST_FILE")
+	if file == "" {
+		file = "./denylist.txt"
+	}
+
+	interval := envInt("DENYLIST_RELOAD_MINUTES", 10)
+
+	go func() {
+		for {
+			loadDenylist(file)
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+func loadDenylist(file string) {
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[ERROR loadDenylist] %v", err)
+			return
+		}
+
+		// A removed file clears the list instead of serving a stale one
+		denylist.Lock()
+		loaded := denylist.info.Modified != 0
+		if loaded {
+			denylist.addresses = make(map[string]bool, 0)
+			denylist.sorted = nil
+			denylist.info = DenylistInfo{File: file, Loaded: time.Now().Unix(), Metadata: make(map[string]string, 0)}
+		}
+		denylist.Unlock()
+
+		if loaded {
+			log.Printf("[loadDenylist] %v was removed, the denylist is now empty", file)
+		}
+		return
+	}
+
+	// Unchanged since the last load
+	denylist.RLock()
+	modified := denylist.info.Modified
+	denylist.RUnlock()
+	if modified == stat.ModTime().Unix() {
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Printf("[ERROR loadDenylist] %v", err)
+		return
+	}
+	defer f.Close()
+
+	addresses := make(map[string]bool, 0)
+	metadata := make(map[string]string, 0)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#") {
+			parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":", 2)
+			if len(parts) == 2 && !strings.Contains(parts[0], " ") {
+				metadata[strings.ToLower(parts[0])] = strings.TrimSpace(parts[1])
+			}
+			continue
+		}
+
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		if line != "" {
+			addresses[line] = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("[ERROR loadDenylist] %v", err)
+		return
+	}
+
+	sorted := make([]string, 0, len(addresses))
+	for address := range addresses {
+		sorted = append(sorted, address)
+	}
+	sort.Strings(sorted)
+
+	info := DenylistInfo{
+		Version:  metadata["version"],
+		File:     file,
+		Modified: stat.ModTime().Unix(),
+		Loaded:   time.Now().Unix(),
+		Count:    len(addresses),
+		Metadata: metadata,
+	}
+
+	denylist.Lock()
+	denylist.addresses = addresses
+	denylist.sorted = sorted
+	denylist.info = info
+	denylist.Unlock()
+
+	log.Printf("[loadDenylist] %v addresses, version %v", info.Count, info.Version)
+}
+
+func isDenylisted(address string) bool {
+
+	denylist.RLock()
+	defer denylist.RUnlock()
+
+	return denylist.addresses[address]
+}
+
+// markDenylisted sets the denylist flag of hotspots read from the cache, which
+// keeps whatever the flag was when the entry was written
+func markDenylisted(hotspots interface{}) {
+
+	denylist.RLock()
+	defer denylist.RUnlock()
+
+	switch list := hotspots.(type) {
+	case []Hotspot:
+		for i := range list {
+			list[i].Denylisted = denylist.addresses[list[i].Address]
+		}
+	case []SingleHotspot:
+		for i := range list {
+			list[i].Denylisted = denylist.addresses[list[i].Address]
+		}
+	case []HotspotStruct:
+		for i := range list {
+			list[i].Denylisted = denylist.addresses[list[i].Address]
+		}
+	case []HotspotSearch:
+		for i := range list {
+			list[i].Denylisted = denylist.addresses[list[i].Address]
+		}
+	}
+}
+
+func GetDenylist(c echo.Context) error {
+
+	page := 0
+	if c.QueryParam("page") != "" {
+		v, err := strconv.Atoi(c.QueryParam("page"))
+		if err != nil || v < 0 {
+			return c.JSON(400, "Invalid page")
+		}
+		page = v
+	}
+
+	limit := 100
+
+	denylist.RLock()
+	info := denylist.info
+	sorted := denylist.sorted
+	denylist.RUnlock()
+
+	response := DenylistPage{info, make([]string, 0)}
+	if page*limit < len(sorted) {
+		response.Hotspots = sorted[page*limit : int(math.Min(float64((page+1)*limit), float64(len(sorted))))]
+	}
+
+	return c.JSON(200, response)
+}
+
+func GetDenylistHotspot(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	denylist.RLock()
+	version := denylist.info.Version
+	denylist.RUnlock()
+
+	return c.JSON(200, DenylistMembership{hash, isDenylisted(hash), version})
+}
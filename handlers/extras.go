@@ -9,6 +9,7 @@ import (
 	"hntscan/db"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 	"unicode"
@@ -442,3 +443,11 @@ func uniqueSlice(input []string) []string {
 	}
 	return u
 }
+
+// envInt reads a positive integer setting, def when unset or invalid
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"math"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+// Forecast horizons in days
+var forecastHorizons = []int{7, 30, 90}
+
+// Days of history used for the daily mean and deviation
+const forecastBaselineDays = 30
+
+// z value of the 90% confidence interval
+const forecastZ = 1.645
+
+const forecastModel = "Daily rewards are the mean of the last 30 full days, with independent daily deviations. " +
+	"Each projected day is halved for every halving (August 1st of odd years) before it. " +
+	"Intervals are 90% normal intervals floored at zero, fiat uses the last oracle price."
+
+func GetHotspotForecast(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	forecast := getHotspotForecast(hash)
+
+	return c.JSON(200, forecast)
+}
+
+func getHotspotForecast(hash string) HotspotForecast {
+
+	var response HotspotForecast
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-forecast-%v", hash)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			now := time.Now().UTC()
+			today := now.Truncate(24 * time.Hour)
+
+			// Full days only, today is still being rewarded. Days without rewards
+			// count as zero.
+			series := getRewardSeries("gateway", hash, today.AddDate(0, 0, -forecastBaselineDays), today, "day", time.UTC)
+			values := make([]float64, 0, forecastBaselineDays)
+			for _, amount := range series.Rewards {
+				values = append(values, float64(amount)/100000000)
+			}
+
+			var mean, stddev float64
+			if len(values) > 0 {
+				for _, v := range values {
+					mean += v
+				}
+				mean = mean / float64(len(values))
+
+				for _, v := range values {
+					stddev += (v - mean) * (v - mean)
+				}
+				stddev = math.Sqrt(stddev / float64(len(values)))
+			}
+
+			price := float64(GetLastOraclePrice()) / 100000000
+
+			response = HotspotForecast{
+				Address:      hash,
+				Model:        forecastModel,
+				BaselineDays: len(values),
+				DailyMean:    mean,
+				DailyStddev:  stddev,
+				Price:        price,
+				NextHalving:  nextHalving(now).Unix(),
+				Horizons:     make([]ForecastHorizon, 0, len(forecastHorizons)),
+				Updated:      now.Unix(),
+			}
+
+			for _, days := range forecastHorizons {
+
+				var expected, variance float64
+				for d := 1; d <= days; d++ {
+					factor := halvingFactor(now, today.AddDate(0, 0, d))
+					expected += mean * factor
+					variance += (stddev * factor) * (stddev * factor)
+				}
+
+				low := math.Max(expected-forecastZ*math.Sqrt(variance), 0)
+				high := expected + forecastZ*math.Sqrt(variance)
+
+				response.Horizons = append(response.Horizons, ForecastHorizon{
+					Days:     days,
+					HNT:      expected,
+					HNTLow:   low,
+					HNTHigh:  high,
+					USD:      expected * price,
+					USDLow:   low * price,
+					USDHigh:  high * price,
+					Halvings: halvingsBetween(now, today.AddDate(0, 0, days)),
+				})
+			}
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// nextHalving returns the first halving after t, HNT emissions halve every two
+// years on August 1st since 2021
+func nextHalving(t time.Time) time.Time {
+
+	year := t.Year()
+	if year%2 == 0 {
+		year++
+	}
+
+	halving := time.Date(year, time.August, 1, 0, 0, 0, 0, time.UTC)
+	if !halving.After(t) {
+		halving = halving.AddDate(2, 0, 0)
+	}
+
+	return halving
+}
+
+func halvingsBetween(from time.Time, to time.Time) int {
+
+	count := 0
+	for h := nextHalving(from); !h.After(to); h = h.AddDate(2, 0, 0) {
+		count++
+	}
+
+	return count
+}
+
+// halvingFactor is the share of today's emission still paid on a future day
+func halvingFactor(from time.Time, day time.Time) float64 {
+	return math.Pow(0.5, float64(halvingsBetween(from, day)))
+}
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"hntscan/db"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/h3-go/v3"
+)
+
+// The offline reverse geocoder works from GeoNames dumps placed in GEOCODER_DIR:
+//
+//	countryInfo.txt            country codes and names (required)
+//	admin1CodesASCII.txt       state/province codes and names (required)
+//	cities1000.txt             populated places, override with GEOCODER_PLACES_FILE (required)
+//	shapes_simplified_low.txt  country boundaries as "geonameid<TAB>geojson" (optional)
+//	admin1_shapes.txt          admin1 boundaries in the same format (optional)
+//
+// Without boundaries the country and state come from the nearest populated place.
+
+type geoPlace struct {
+	ID      int64
+	Name    string
+	Lat     float64
+	Lng     float64
+	Country string
+	Admin1  string
+}
+
+type geoShape struct {
+	Code     string
+	Polygons [][][][2]float64
+	MinLat   float64
+	MaxLat   float64
+	MinLng   float64
+	MaxLng   float64
+}
+
+type reverseGeocoder struct {
+	sync.RWMutex
+	ready         bool
+	countries     map[string]string
+	admin1        map[string]string
+	places        []geoPlace
+	grid          map[[2]int][]int
+	countryShapes []geoShape
+	admin1Shapes  []geoShape
+	maxCityMeters float64
+	writeBack     bool
+}
+
+var geocoder = &reverseGeocoder{}
+
+// StartGeocoder loads the GeoNames files in the background. Lookups return
+// nothing until the data is loaded.
+func StartGeocoder() {
+
+	dir := os.Getenv("GEOCODER_DIR")
+	if dir == "" {
+		dir = "./geodata"
+	}
+
+	placesFile := os.Getenv("GEOCODER_PLACES_FILE")
+	if placesFile == "" {
+		placesFile = "cities1000.txt"
+	}
+
+	maxCityKm := 30.0
+	if v, err := strconv.ParseFloat(os.Getenv("GEOCODER_MAX_CITY_KM"), 64); err == nil && v > 0 {
+		maxCityKm = v
+	}
+
+	writeBack := os.Getenv("GEOCODER_WRITEBACK") == "true"
+	if writeBack {
+		// locations belongs to the ETL, the GeoNames ids are kept in a table of our own
+		_, err := db.DB.Exec(`CREATE TABLE IF NOT EXISTS geocoded_locations (
+								location TEXT PRIMARY KEY,
+								geonames_id BIGINT,
+								created_at BIGINT
+							)`)
+		if err != nil {
+			log.Printf("[ERROR StartGeocoder] %v", err)
+			writeBack = false
+		}
+	}
+
+	go func() {
+
+		start := time.Now()
+
+		countries, countryIDs, err := loadGeoNamesCountries(filepath.Join(dir, "countryInfo.txt"))
+		if err != nil {
+			log.Printf("[ERROR StartGeocoder] %v", err)
+			return
+		}
+
+		admin1, admin1IDs, err := loadGeoNamesAdmin1(filepath.Join(dir, "admin1CodesASCII.txt"))
+		if err != nil {
+			log.Printf("[ERROR StartGeocoder] %v", err)
+			return
+		}
+
+		places, grid, err := loadGeoNamesPlaces(filepath.Join(dir, placesFile))
+		if err != nil {
+			log.Printf("[ERROR StartGeocoder] %v", err)
+			return
+		}
+
+		// Boundaries are optional
+		countryShapes, err := loadGeoNamesShapes(filepath.Join(dir, "shapes_simplified_low.txt"), countryIDs)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[ERROR StartGeocoder] %v", err)
+		}
+
+		admin1Shapes, err := loadGeoNamesShapes(filepath.Join(dir, "admin1_shapes.txt"), admin1IDs)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[ERROR StartGeocoder] %v", err)
+		}
+
+		geocoder.Lock()
+		geocoder.countries = countries
+		geocoder.admin1 = admin1
+		geocoder.places = places
+		geocoder.grid = grid
+		geocoder.countryShapes = countryShapes
+		geocoder.admin1Shapes = admin1Shapes
+		geocoder.maxCityMeters = maxCityKm * 1000
+		geocoder.writeBack = writeBack
+		geocoder.ready = true
+		geocoder.Unlock()
+
+		log.Printf("Geocoder loaded %v places, %v country and %v admin1 boundaries in %v", len(places), len(countryShapes), len(admin1Shapes), time.Since(start))
+	}()
+}
+
+// reverseGeocode fills a GeoCode for an H3 location from the GeoNames data. The
+// write-back happens after the read lock is released, so the database is never
+// queried while holding it.
+func reverseGeocode(location string) (GeoCode, bool) {
+
+	geoCode, geoNamesID, writeBack, ok := lookupGeoNames(location)
+	if !ok {
+		return geoCode, false
+	}
+
+	if writeBack {
+		saveGeocodedLocation(location, geoCode, geoNamesID)
+	}
+
+	return geoCode, true
+}
+
+// lookupGeoNames returns the GeoCode of the location and the GeoNames ID of the
+// matched city, 0 when no city is close enough
+func lookupGeoNames(location string) (GeoCode, int64, bool, bool) {
+
+	geocoder.RLock()
+	defer geocoder.RUnlock()
+
+	if !geocoder.ready || location == "" || !h3.IsValid(h3.FromString(location)) {
+		return GeoCode{}, 0, false, false
+	}
+
+	lat, lng := H3ToLatLong(location)
+
+	country := findShape(geocoder.countryShapes, lat, lng)
+	state := findShape(geocoder.admin1Shapes, lat, lng)
+
+	place, distance := nearestPlace(lat, lng, country)
+
+	if place != nil {
+		if country == "" {
+			country = place.Country
+		}
+		if state == "" && place.Country == country {
+			state = place.Country + "." + place.Admin1
+		}
+	}
+
+	if country == "" {
+		return GeoCode{}, 0, false, false
+	}
+
+	geoCode := GeoCode{
+		ShortCountry: country,
+		LongCountry:  geocoder.countries[country],
+	}
+
+	if state != "" {
+		geoCode.ShortState = strings.TrimPrefix(state, country+".")
+		geoCode.LongState = geocoder.admin1[state]
+	}
+
+	// city_id stays empty, GeoNames IDs are a different scheme than the one the
+	// city endpoints group on. The ID goes to geocoded_locations instead.
+	var geoNamesID int64
+	if place != nil && distance <= geocoder.maxCityMeters {
+		geoCode.ShortCity = place.Name
+		geoCode.LongCity = place.Name
+		geoNamesID = place.ID
+	}
+
+	return geoCode, geoNamesID, geocoder.writeBack, true
+}
+
+func saveGeocodedLocation(location string, g GeoCode, geoNamesID int64) {
+
+	_, err := db.DB.Exec(`INSERT INTO locations
+							(location, short_street, long_street, short_city, long_city, short_state, long_state, short_country, long_country, city_id)
+						VALUES
+							($1, '', '', $2, $3, $4, $5, $6, $7, NULL)
+						ON CONFLICT (location) DO NOTHING`,
+		location, g.ShortCity, g.LongCity, g.ShortState, g.LongState, g.ShortCountry, g.LongCountry)
+	if err != nil {
+		log.Printf("[ERROR saveGeocodedLocation] %v", err)
+		return
+	}
+
+	_, err = db.DB.Exec(`INSERT INTO geocoded_locations (location, geonames_id, created_at) VALUES ($1, NULLIF($2, 0), $3) ON CONFLICT (location) DO NOTHING`,
+		location, geoNamesID, time.Now().Unix())
+	if err != nil {
+		log.Printf("[ERROR saveGeocodedLocation] %v", err)
+	}
+}
+
+// nearestPlace looks in the surrounding 1 degree grid cells. When country is set
+// only places in that country are considered.
+func nearestPlace(lat float64, lng float64, country string) (*geoPlace, float64) {
+
+	var nearest *geoPlace
+	nearestDistance := math.MaxFloat64
+
+	origin := h3.GeoCoord{Latitude: lat, Longitude: lng}
+	cellLat, cellLng := int(math.Floor(lat)), int(math.Floor(lng))
+
+	for dLat := -1; dLat <= 1; dLat++ {
+		for dLng := -1; dLng <= 1; dLng++ {
+			for _, i := range geocoder.grid[[2]int{cellLat + dLat, cellLng + dLng}] {
+
+				place := &geocoder.places[i]
+				if country != "" && place.Country != country {
+					continue
+				}
+
+				distance := h3.PointDistM(origin, h3.GeoCoord{Latitude: place.Lat, Longitude: place.Lng})
+				if distance < nearestDistance {
+					nearest = place
+					nearestDistance = distance
+				}
+			}
+		}
+	}
+
+	return nearest, nearestDistance
+}
+
+func findShape(shapes []geoShape, lat float64, lng float64) string {
+
+	for _, shape := range shapes {
+
+		if lat < shape.MinLat || lat > shape.MaxLat || lng < shape.MinLng || lng > shape.MaxLng {
+			continue
+		}
+
+		for _, polygon := range shape.Polygons {
+			if pointInPolygon(polygon, lat, lng) {
+				return shape.Code
+			}
+		}
+	}
+
+	return ""
+}
+
+// pointInPolygon uses ray casting on the outer ring and excludes the holes
+func pointInPolygon(polygon [][][2]float64, lat float64, lng float64) bool {
+
+	for i, ring := range polygon {
+		inside := pointInRing(ring, lat, lng)
+		if i == 0 && !inside {
+			return false
+		}
+		if i > 0 && inside {
+			return false
+		}
+	}
+
+	return len(polygon) > 0
+}
+
+func pointInRing(ring [][2]float64, lat float64, lng float64) bool {
+
+	inside := false
+
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// readGeoNamesFile calls fn with the tab separated columns of every non comment line
+func readGeoNamesFile(path string, fn func(columns []string)) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fn(strings.Split(line, "\t"))
+	}
+
+	return scanner.Err()
+}
+
+// loadGeoNamesCountries returns ISO code -> name and geonameid -> ISO code
+func loadGeoNamesCountries(path string) (map[string]string, map[string]string, error) {
+
+	countries := make(map[string]string, 0)
+	ids := make(map[string]string, 0)
+
+	err := readGeoNamesFile(path, func(columns []string) {
+		if len(columns) > 16 {
+			countries[columns[0]] = columns[4]
+			ids[columns[16]] = columns[0]
+		}
+	})
+
+	return countries, ids, err
+}
+
+// loadGeoNamesAdmin1 returns "CC.ADMIN1" -> name and geonameid -> "CC.ADMIN1"
+func loadGeoNamesAdmin1(path string) (map[string]string, map[string]string, error) {
+
+	admin1 := make(map[string]string, 0)
+	ids := make(map[string]string, 0)
+
+	err := readGeoNamesFile(path, func(columns []string) {
+		if len(columns) > 3 {
+			admin1[columns[0]] = columns[1]
+			ids[columns[3]] = columns[0]
+		}
+	})
+
+	return admin1, ids, err
+}
+
+func loadGeoNamesPlaces(path string) ([]geoPlace, map[[2]int][]int, error) {
+
+	places := make([]geoPlace, 0)
+	grid := make(map[[2]int][]int, 0)
+
+	err := readGeoNamesFile(path, func(columns []string) {
+
+		// Only populated places (feature class P)
+		if len(columns) < 11 || columns[6] != "P" {
+			return
+		}
+
+		id, _ := strconv.ParseInt(columns[0], 10, 64)
+		lat, errLat := strconv.ParseFloat(columns[4], 64)
+		lng, errLng := strconv.ParseFloat(columns[5], 64)
+		if errLat != nil || errLng != nil {
+			return
+		}
+
+		cell := [2]int{int(math.Floor(lat)), int(math.Floor(lng))}
+		grid[cell] = append(grid[cell], len(places))
+
+		places = append(places, geoPlace{id, columns[1], lat, lng, columns[8], columns[10]})
+	})
+
+	return places, grid, err
+}
+
+// loadGeoNamesShapes reads "geonameid<TAB>geojson" lines and keeps the shapes
+// whose geonameid is in ids
+func loadGeoNamesShapes(path string, ids map[string]string) ([]geoShape, error) {
+
+	shapes := make([]geoShape, 0)
+
+	type geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+
+	err := readGeoNamesFile(path, func(columns []string) {
+
+		if len(columns) < 2 {
+			return
+		}
+
+		code, ok := ids[columns[0]]
+		if !ok {
+			return
+		}
+
+		var g geometry
+		if err := json.Unmarshal([]byte(columns[1]), &g); err != nil {
+			return
+		}
+
+		var polygons [][][][2]float64
+		switch g.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+				return
+			}
+			polygons = append(polygons, polygon)
+		case "MultiPolygon":
+			if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+				return
+			}
+		default:
+			return
+		}
+
+		shape := geoShape{code, polygons, 90, -90, 180, -180}
+		for _, polygon := range polygons {
+			if len(polygon) == 0 {
+				continue
+			}
+			for _, point := range polygon[0] {
+				shape.MinLng = math.Min(shape.MinLng, point[0])
+				shape.MaxLng = math.Max(shape.MaxLng, point[0])
+				shape.MinLat = math.Min(shape.MinLat, point[1])
+				shape.MaxLat = math.Max(shape.MaxLat, point[1])
+			}
+		}
+
+		shapes = append(shapes, shape)
+	})
+
+	return shapes, err
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/uber/h3-go/v3"
+)
+
+// Health score weights, the score is the sum of the parts (0-100)
+const healthActivityWeight = 25
+const healthBeaconWeight = 20
+const healthWitnessWeight = 25
+const healthRewardWeight = 15
+const healthConnectivityWeight = 15
+
+// A healthy hotspot beacons roughly once a day
+const healthExpectedBeacons = 7
+
+// Reward drop (percentage points) tolerated against the hex peers, and against
+// the previous week when the hotspot has no peers to compare with
+const healthPeerDropTolerance = 10
+const healthRewardDropTolerance = 25
+
+func GetHotspotHealth(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	health := getHotspotHealth(hash)
+
+	return c.JSON(200, health)
+}
+
+func getHotspotHealth(hash string) HotspotHealth {
+
+	var health HotspotHealth
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-health-%v", hash)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			beacons, witnesses := getHotspotPocSummary(hash, 7)
+			rewards := getHotspotRewardTrend(hash)
+			relay := getHotspotRelayStatus(hash)
+			active := getHotspotStatus(hash)
+
+			activity := HealthActivity{active.Active, active.Timestamp, 0}
+			if active.Timestamp > 0 {
+				activity.HoursSince = (time.Now().Unix() - active.Timestamp) / 3600
+			}
+
+			health = HotspotHealth{
+				Address:     hash,
+				Activity:    activity,
+				Beacons:     beacons,
+				Witnesses:   witnesses,
+				Rewards:     rewards,
+				Relay:       relay,
+				Updated:     time.Now().Unix(),
+				Suggestions: make([]string, 0),
+			}
+
+			scoreHotspotHealth(&health)
+
+			if err := enc.Encode(health); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&health); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return health
+}
+
+// scoreHotspotHealth fills the score and the recommendations
+func scoreHotspotHealth(health *HotspotHealth) {
+
+	score := 0.0
+	activeHours, _ := statusThresholds()
+
+	// Activity
+	switch {
+	case health.Activity.LastActivity == 0:
+		health.Suggestions = append(health.Suggestions, "No activity found on chain, check that the hotspot is powered and connected.")
+	case health.Activity.HoursSince < activeHours:
+		score += healthActivityWeight
+	case health.Activity.HoursSince < activeHours*2:
+		score += healthActivityWeight * 0.4
+		health.Suggestions = append(health.Suggestions, fmt.Sprintf("No activity in the last %v hours, check power and internet connection.", activeHours))
+	default:
+		health.Suggestions = append(health.Suggestions, fmt.Sprintf("No activity for more than %v hours, the hotspot is probably offline.", activeHours*2))
+	}
+
+	// Beacon cadence
+	score += healthBeaconWeight * math.Min(float64(health.Beacons.Count)/healthExpectedBeacons, 1)
+	if health.Beacons.Count == 0 {
+		health.Suggestions = append(health.Suggestions, "No beacons in the last 7 days, restart the hotspot or check the packet forwarder.")
+	} else if health.Beacons.Count < healthExpectedBeacons/2 {
+		health.Suggestions = append(health.Suggestions, "Few beacons in the last 7 days, check that the hotspot is fully synced.")
+	}
+
+	// Witness validity
+	totalWitnesses := health.Witnesses.Valid + health.Witnesses.Invalid
+	if totalWitnesses > 0 {
+		score += healthWitnessWeight * health.Witnesses.ValidRatio
+	} else {
+		health.Suggestions = append(health.Suggestions, "No witnesses in the last 7 days, improve antenna placement (height, line of sight).")
+	}
+
+	if totalWitnesses > 0 && health.Witnesses.ValidRatio < 0.5 && len(health.Witnesses.InvalidReasons) > 0 {
+		health.Suggestions = append(health.Suggestions, invalidReasonSuggestion(health.Witnesses.InvalidReasons[0].Reason))
+	}
+
+	// Reward trend compared with the hex neighbours. Without peers (isolated
+	// hotspot, or density model still loading) only large drops count.
+	var expectedChange float64
+	tolerance := float64(healthRewardDropTolerance)
+	if health.Rewards.Peers > 0 {
+		expectedChange = health.Rewards.PeerChange
+		tolerance = healthPeerDropTolerance
+	}
+
+	if health.Rewards.Previous7D == 0 && health.Rewards.Last7D == 0 {
+		health.Suggestions = append(health.Suggestions, "No rewards in the last 14 days.")
+	} else if health.Rewards.Change >= expectedChange-tolerance {
+		score += healthRewardWeight
+	} else {
+		gap := math.Min((expectedChange-health.Rewards.Change)/100, 1)
+		score += healthRewardWeight * (1 - gap)
+		if health.Rewards.Peers > 0 {
+			health.Suggestions = append(health.Suggestions, "Rewards dropped more than those of hotspots in the same hex.")
+		} else {
+			health.Suggestions = append(health.Suggestions, fmt.Sprintf("Rewards dropped more than %v%% compared with the previous week.", healthRewardDropTolerance))
+		}
+	}
+
+	// Connectivity
+	switch {
+	case !health.Relay.Online:
+		health.Suggestions = append(health.Suggestions, "The hotspot is offline on the p2p network.")
+	case health.Relay.Relayed:
+		score += healthConnectivityWeight / 2
+		health.Suggestions = append(health.Suggestions, "The hotspot is relayed, open port 44158 (TCP) on the router.")
+	default:
+		score += healthConnectivityWeight
+	}
+
+	health.Score = int(math.Round(score))
+}
+
+func invalidReasonSuggestion(reason string) string {
+
+	switch {
+	case strings.Contains(reason, "too_close"):
+		return "Most witnesses are invalid because the hotspots are too close, move the hotspot further from its neighbours."
+	case strings.Contains(reason, "rssi") || strings.Contains(reason, "signal"):
+		return "Most witnesses are invalid because of the signal strength, check the antenna gain and elevation settings."
+	case strings.Contains(reason, "region") || strings.Contains(reason, "channel") || strings.Contains(reason, "frequency"):
+		return "Most witnesses are invalid because of the frequency or region, check the hotspot region settings."
+	}
+
+	return fmt.Sprintf("Most witnesses are invalid (%v).", reason)
+}
+
+// getHotspotPocSummary counts beacons and witnesses over the last days
+func getHotspotPocSummary(address string, days int) (HealthBeacons, HealthWitnesses) {
+
+	beacons := HealthBeacons{}
+	witnesses := HealthWitnesses{InvalidReasons: make([]InvalidReasons, 0)}
+
+	reasons := make(map[string]int, 0)
+	var previousBeacon int64
+	var intervals int64
+
+	for _, event := range getHotspotPocEvents(address, time.Now().AddDate(0, 0, -days).Unix()) {
+
+		switch event.Role {
+		case "challengee":
+			beacons.Count++
+			if previousBeacon > 0 {
+				intervals += event.Time - previousBeacon
+			}
+			previousBeacon = event.Time
+			beacons.LastBeacon = event.Time
+		case "witness":
+			if event.Valid {
+				witnesses.Valid++
+			} else {
+				witnesses.Invalid++
+				reasons[event.InvalidReason]++
+			}
+		}
+	}
+
+	if beacons.Count > 1 {
+		beacons.AvgIntervalHours = float64(intervals) / float64(beacons.Count-1) / 3600
+	}
+
+	if witnesses.Valid+witnesses.Invalid > 0 {
+		witnesses.ValidRatio = float64(witnesses.Valid) / float64(witnesses.Valid+witnesses.Invalid)
+	}
+
+	for reason, count := range reasons {
+		witnesses.InvalidReasons = append(witnesses.InvalidReasons, InvalidReasons{reason, count})
+	}
+
+	sort.Slice(witnesses.InvalidReasons, func(i, j int) bool {
+		return witnesses.InvalidReasons[i].Count > witnesses.InvalidReasons[j].Count
+	})
+
+	if len(witnesses.InvalidReasons) > 3 {
+		witnesses.InvalidReasons = witnesses.InvalidReasons[:3]
+	}
+
+	return beacons, witnesses
+}
+
+// getHotspotRewardTrend compares the last 7 days of rewards with the 7 days before,
+// for the hotspot and for the other hotspots in its res-8 hex
+func getHotspotRewardTrend(address string) HealthRewards {
+
+	trend := HealthRewards{}
+
+	hotspotData := getHotspotData(address)
+	if len(hotspotData) == 0 || hotspotData[0].Location == "" {
+		return trend
+	}
+
+	peers := make([]string, 0)
+	for _, peer := range hotspotsInRes8(h3.ToParent(h3.FromString(hotspotData[0].Location), 8)) {
+		if peer.Address != address {
+			peers = append(peers, peer.Address)
+		}
+	}
+
+	trend.Peers = len(peers)
+
+	now := time.Now()
+	sevenDaysAgo := now.AddDate(0, 0, -7).Unix()
+	fourteenDaysAgo := now.AddDate(0, 0, -14).Unix()
+
+	rows, err := db.DB.Query(`SELECT
+								gateway,
+								COALESCE(SUM(amount) FILTER (WHERE time >= $2), 0),
+								COALESCE(SUM(amount) FILTER (WHERE time < $2), 0)
+							FROM
+								rewards
+							WHERE
+								gateway = ANY($1)
+								AND time >= $3
+							GROUP BY
+								gateway`, pq.Array(append(peers, address)), sevenDaysAgo, fourteenDaysAgo)
+	if err != nil {
+		log.Printf("[ERROR getHotspotRewardTrend] %v", err)
+		return trend
+	}
+
+	defer rows.Close()
+
+	var gateway sql.NullString
+	var last, previous sql.NullInt64
+	var peersLast, peersPrevious int64
+
+	for rows.Next() {
+
+		err := rows.Scan(&gateway, &last, &previous)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+
+		if gateway.String == address {
+			trend.Last7D = last.Int64
+			trend.Previous7D = previous.Int64
+		} else {
+			peersLast += last.Int64
+			peersPrevious += previous.Int64
+		}
+	}
+
+	trend.Change = percentageChange(trend.Previous7D, trend.Last7D)
+	trend.PeerChange = percentageChange(peersPrevious, peersLast)
+
+	return trend
+}
+
+func percentageChange(previous int64, current int64) float64 {
+
+	if previous == 0 {
+		return 0
+	}
+
+	return float64(current-previous) / float64(previous) * 100
+}
+
+// getHotspotRelayStatus reads the p2p state from gateway_status. Relayed hotspots
+// only announce p2p-circuit addresses.
+func getHotspotRelayStatus(address string) HealthRelay {
+
+	relay := HealthRelay{ListenAddrs: make([]string, 0)}
+
+	row := db.DB.QueryRow(`SELECT online, peer_timestamp, listen_addrs FROM gateway_status WHERE address = $1`, address)
+
+	var online, peerTimestamp, listenAddrs sql.NullString
+
+	err := row.Scan(&online, &peerTimestamp, &listenAddrs)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[ERROR getHotspotRelayStatus] %v", err)
+		}
+		return relay
+	}
+
+	return newHealthRelay(online.String, peerTimestamp.String, listenAddrs.String)
+}
+
+func newHealthRelay(online string, peerTimestamp string, listenAddrs string) HealthRelay {
+
+	relay := HealthRelay{
+		Online:        online == "online",
+		PeerTimestamp: timestamptzConverter(peerTimestamp),
+		ListenAddrs:   parseListenAddrs(listenAddrs),
+	}
+
+	// A hotspot with any direct address is reachable without a relay
+	relay.Relayed = len(relay.ListenAddrs) > 0
+	for _, addr := range relay.ListenAddrs {
+		if !strings.Contains(addr, "p2p-circuit") {
+			relay.Relayed = false
+			break
+		}
+	}
+
+	return relay
+}
+
+// parseListenAddrs accepts both JSON arrays and Postgres array literals
+func parseListenAddrs(value string) []string {
+
+	addrs := make([]string, 0)
+
+	if value == "" {
+		return addrs
+	}
+
+	if err := json.Unmarshal([]byte(value), &addrs); err == nil {
+		return addrs
+	}
+
+	for _, addr := range strings.Split(strings.Trim(value, "{}"), ",") {
+		addr = strings.Trim(addr, `" `)
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/uber/h3-go/v3"
+)
+
+func GetHotspotLink(c echo.Context) error {
+
+	hashA := c.Param("a")
+	hashB := c.Param("b")
+
+	if hashA == "" || hashB == "" || hashA == hashB {
+		return c.JSON(400, "Bad request")
+	}
+
+	days := 30
+	if c.QueryParam("days") != "" {
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > pocMaxDays() {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", pocMaxDays()))
+		}
+		days = v
+	}
+
+	link := getHotspotLinkHistory(hashA, hashB, days)
+
+	return c.JSON(200, link)
+}
+
+// getHotspotLinkHistory lists the PoC receipts where one hotspot beaconed and the
+// other one witnessed, in both directions, over the last days
+func getHotspotLinkHistory(hashA string, hashB string, days int) LinkHistory {
+
+	var response LinkHistory
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-link-%v-%v-%v", hashA, hashB, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			response = LinkHistory{
+				A:    hashA,
+				B:    hashB,
+				Days: days,
+			}
+
+			// Current distance between the two hotspots
+			dataA := getHotspotData(hashA)
+			dataB := getHotspotData(hashB)
+			if len(dataA) == 1 && len(dataB) == 1 && dataA[0].Location != "" && dataB[0].Location != "" {
+				response.Distance = int(h3.PointDistM(h3.ToGeo(h3.FromString(dataA[0].Location)), h3.ToGeo(h3.FromString(dataB[0].Location))))
+			}
+
+			aToB := make([]LinkInteraction, 0)
+			bToA := make([]LinkInteraction, 0)
+
+			startTime := time.Now().AddDate(0, 0, -days).Unix()
+
+			rows, err := db.DB.Query(`SELECT
+										t.hash,
+										t.block,
+										t.time,
+										t.fields
+									FROM
+										transaction_actors ta
+										INNER JOIN transaction_actors tb ON tb.transaction_hash = ta.transaction_hash
+										INNER JOIN transactions t ON t.hash = ta.transaction_hash
+									WHERE
+										ta.actor = $1
+										AND tb.actor = $2
+										AND ta.actor_role IN ('challengee', 'witness')
+										AND tb.actor_role IN ('challengee', 'witness')
+										AND t.time >= $3
+									ORDER BY
+										t.block ASC`, hashA, hashB, startTime)
+			if err != nil {
+				log.Printf("[ERROR getHotspotLinkHistory] %v", err)
+				return response
+			}
+
+			defer rows.Close()
+
+			var txHash, fields sql.NullString
+			var block, timestamp sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&txHash, &block, &timestamp, &fields)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				receipt := new(WitnessStruct)
+				json.Unmarshal([]byte(fields.String), &receipt)
+
+				for _, path := range receipt.Path {
+
+					// Only the beaconer/witness pair matters, both hotspots can be witnesses of a third one
+					var witnessGateway string
+					switch path.Challengee {
+					case hashA:
+						witnessGateway = hashB
+					case hashB:
+						witnessGateway = hashA
+					default:
+						continue
+					}
+
+					for _, witness := range path.Witnesses {
+
+						if witness.Gateway != witnessGateway {
+							continue
+						}
+
+						distance := 0
+						if path.ChallengeeLocation != "" && witness.Location != "" {
+							distance = int(h3.PointDistM(h3.ToGeo(h3.FromString(path.ChallengeeLocation)), h3.ToGeo(h3.FromString(witness.Location))))
+						}
+
+						interaction := LinkInteraction{
+							txHash.String,
+							block.Int64,
+							timestamp.Int64,
+							witness.Signal,
+							witness.Snr,
+							witness.Frequency,
+							witness.Datarate,
+							witness.Channel,
+							distance,
+							witness.IsValid,
+							witness.InvalidReason,
+						}
+
+						if path.Challengee == hashA {
+							aToB = append(aToB, interaction)
+						} else {
+							bToA = append(bToA, interaction)
+						}
+					}
+				}
+			}
+			rows.Close()
+
+			response.AToB = newLinkDirection(hashA, hashB, aToB)
+			response.BToA = newLinkDirection(hashB, hashA, bToA)
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// newLinkDirection summarizes the interactions of a beaconer heard by a witness
+func newLinkDirection(beaconer string, witness string, interactions []LinkInteraction) LinkDirection {
+
+	direction := LinkDirection{
+		Beaconer:       beaconer,
+		Witness:        witness,
+		Count:          len(interactions),
+		InvalidReasons: make([]InvalidReasons, 0),
+		Interactions:   interactions,
+	}
+
+	// Interactions of the same block share a timestamp, keep each of them
+	rssi := make([]TimePoint, 0, len(interactions))
+	snr := make([]TimePoint, 0, len(interactions))
+	reasons := make(map[string]int, 0)
+
+	for _, interaction := range interactions {
+
+		rssi = append(rssi, TimePoint{interaction.Time, float64(interaction.RSSI)})
+		snr = append(snr, TimePoint{interaction.Time, interaction.SNR})
+
+		if interaction.Valid {
+			direction.Valid++
+		} else {
+			direction.Invalid++
+			reasons[interaction.InvalidReason]++
+		}
+	}
+
+	for reason, count := range reasons {
+		direction.InvalidReasons = append(direction.InvalidReasons, InvalidReasons{reason, count})
+	}
+
+	sort.Slice(direction.InvalidReasons, func(i, j int) bool {
+		return direction.InvalidReasons[i].Count > direction.InvalidReasons[j].Count
+	})
+
+	direction.RSSI = newTimeSeriesPoints(rssi, "raw", "dbm")
+	direction.SNR = newTimeSeriesPoints(snr, "raw", "db")
+
+	if len(interactions) > 0 {
+		direction.AvgRSSI = direction.RSSI.Sum / float64(len(interactions))
+		direction.AvgSNR = direction.SNR.Sum / float64(len(interactions))
+	}
+
+	return direction
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/uber/h3-go/v3"
+)
+
+func GetHotspotLocations(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	history := getHotspotLocationHistory(hash)
+
+	return c.JSON(200, history)
+}
+
+// getHotspotLocationHistory lists every location assertion of a hotspot, newest
+// first, with the distance moved compared to the previous assertion
+func getHotspotLocationHistory(hash string) LocationHistory {
+
+	history := LocationHistory{hash, 0, 0, make([]LocationAssertion, 0)}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-locations-%v", hash)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			rows, err := db.DB.Query(`SELECT
+										t.hash,
+										t.block,
+										t.time,
+										t.type,
+										t.fields
+									FROM
+										transaction_actors ta
+										INNER JOIN transactions t ON ta.transaction_hash = t.hash
+									WHERE
+										ta.actor = $1
+										AND ta.actor_role = 'gateway'
+										AND t.type LIKE 'assert_location%'
+									ORDER BY
+										t.block ASC`, hash)
+			if err != nil {
+				log.Printf("[ERROR getHotspotLocationHistory] %v", err)
+				return history
+			}
+
+			defer rows.Close()
+
+			var txHash, txType, fields sql.NullString
+			var block, timestamp sql.NullInt64
+
+			assertions := make([]LocationAssertion, 0)
+			previousLocation := ""
+
+			for rows.Next() {
+
+				err := rows.Scan(&txHash, &block, &timestamp, &txType, &fields)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				gateway := new(GatewayParsed)
+				json.Unmarshal([]byte(fields.String), &gateway)
+
+				// assert_location_v2 can update gain or elevation without moving
+				distance := 0
+				moved := false
+				if previousLocation != "" && gateway.Location != "" && gateway.Location != previousLocation {
+					distance = int(h3.PointDistM(h3.ToGeo(h3.FromString(previousLocation)), h3.ToGeo(h3.FromString(gateway.Location))))
+					moved = true
+					history.Moves++
+					history.TotalDistance += distance
+				}
+
+				if gateway.Location != "" {
+					previousLocation = gateway.Location
+				}
+
+				geolocation := getGeolocationData(gateway.Location)
+
+				assertions = append(assertions, LocationAssertion{
+					txHash.String,
+					txType.String,
+					block.Int64,
+					timestamp.Int64,
+					Location{
+						gateway.Location,
+						geolocation.LongCountry,
+						geolocation.ShortCountry,
+						geolocation.LongCity,
+						geolocation.LongStreet,
+					},
+					calculatePlace(gateway.Location),
+					gateway.Gain,
+					gateway.Elevation,
+					gateway.StakingFee,
+					gateway.Fee,
+					gateway.Payer,
+					moved,
+					distance,
+				})
+			}
+			rows.Close()
+
+			// Newest first
+			for i := len(assertions) - 1; i >= 0; i-- {
+				history.Assertions = append(history.Assertions, assertions[i])
+			}
+
+			if err := enc.Encode(history); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&history); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return history
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+func GetHotspotOwners(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	history := getHotspotOwnershipHistory(hash)
+
+	return c.JSON(200, history)
+}
+
+// getHotspotOwnershipHistory rebuilds the ownership chain from the add_gateway and
+// transfer_hotspot transactions, newest owner first
+func getHotspotOwnershipHistory(hash string) OwnershipHistory {
+
+	history := OwnershipHistory{hash, 0, make([]OwnerTenure, 0)}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-owners-%v", hash)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			rows, err := db.DB.Query(`SELECT DISTINCT
+										t.hash,
+										t.block,
+										t.time,
+										t.type,
+										t.fields
+									FROM
+										transaction_actors ta
+										INNER JOIN transactions t ON ta.transaction_hash = t.hash
+									WHERE
+										ta.actor = $1
+										AND t.type IN ('add_gateway_v1', 'transfer_hotspot_v1', 'transfer_hotspot_v2')
+									ORDER BY
+										t.block ASC`, hash)
+			if err != nil {
+				log.Printf("[ERROR getHotspotOwnershipHistory] %v", err)
+				return history
+			}
+
+			defer rows.Close()
+
+			var txHash, txType, fields sql.NullString
+			var block, timestamp sql.NullInt64
+
+			tenures := make([]OwnerTenure, 0)
+
+			for rows.Next() {
+
+				err := rows.Scan(&txHash, &block, &timestamp, &txType, &fields)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				transfer := new(TransferHotspotFields)
+				json.Unmarshal([]byte(fields.String), &transfer)
+
+				tenure := OwnerTenure{
+					Hash:  txHash.String,
+					Type:  txType.String,
+					Block: block.Int64,
+					From:  timestamp.Int64,
+				}
+
+				switch txType.String {
+				case "add_gateway_v1":
+					tenure.Owner = transfer.Owner
+				case "transfer_hotspot_v1":
+					tenure.Owner = transfer.Buyer
+					tenure.PreviousOwner = transfer.Seller
+					tenure.SaleAmount = transfer.AmountToSeller
+				case "transfer_hotspot_v2":
+					tenure.Owner = transfer.NewOwner
+					tenure.PreviousOwner = transfer.Owner
+				}
+
+				// Close the previous tenure
+				if len(tenures) > 0 {
+					tenures[len(tenures)-1].To = timestamp.Int64
+					history.Transfers++
+				}
+
+				tenures = append(tenures, tenure)
+			}
+			rows.Close()
+
+			for i := range tenures {
+				tenures[i].Rewards = getHotspotRewardsBetween(hash, tenures[i].From, tenures[i].To)
+			}
+
+			// Newest owner first
+			for i := len(tenures) - 1; i >= 0; i-- {
+				history.Owners = append(history.Owners, tenures[i])
+			}
+
+			if err := enc.Encode(history); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&history); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return history
+}
+
+// getHotspotRewardsBetween sums the hotspot rewards in [from, to). to = 0 means until now.
+func getHotspotRewardsBetween(hash string, from int64, to int64) int64 {
+
+	var amount sql.NullInt64
+
+	row := db.DB.QueryRow(`SELECT SUM(amount) FROM rewards WHERE gateway = $1 AND time >= $2 AND ($3 = 0 OR time < $3)`, hash, from, to)
+
+	err := row.Scan(&amount)
+	if err != nil {
+		log.Printf("[ERROR getHotspotRewardsBetween] %v", err)
+	}
+
+	return amount.Int64
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+func GetHotspotPocActivity(c echo.Context) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	days := 7
+	if c.QueryParam("days") != "" {
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > pocMaxDays() {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", pocMaxDays()))
+		}
+		days = v
+	}
+
+	activity := getHotspotPocActivity(hash, days)
+
+	return c.JSON(200, activity)
+}
+
+// pocMaxDays is the largest window accepted, POC_MAX_DAYS (default 30)
+func pocMaxDays() int {
+
+	return envInt("POC_MAX_DAYS", 30)
+}
+
+// getHotspotPocActivity counts, per UTC day, the beacons sent, the witnesses made
+// (valid and invalid) and the challenges issued over the last days. Averages are
+// per day over the whole window, including today.
+func getHotspotPocActivity(address string, days int) PocActivity {
+
+	var response PocActivity
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("hotspot-poc-%v-%v", address, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			today := time.Now().UTC().Truncate(24 * time.Hour)
+			startDate := today.AddDate(0, 0, -(days - 1))
+
+			beacons := emptyDaySeries(startDate, today)
+			valid := emptyDaySeries(startDate, today)
+			invalid := emptyDaySeries(startDate, today)
+			challenges := emptyDaySeries(startDate, today)
+
+			for _, event := range getHotspotPocEvents(address, startDate.Unix()) {
+
+				day := time.Unix(event.Time, 0).UTC().Truncate(24 * time.Hour).Unix()
+
+				switch event.Role {
+				case "challengee":
+					beacons[day]++
+				case "challenger":
+					challenges[day]++
+				case "witness":
+					if event.Valid {
+						valid[day]++
+					} else {
+						invalid[day]++
+					}
+				}
+			}
+
+			response = PocActivity{
+				Address:          address,
+				Days:             days,
+				BeaconsSent:      newTimeSeriesInt(beacons, "day", "count"),
+				WitnessesValid:   newTimeSeriesInt(valid, "day", "count"),
+				WitnessesInvalid: newTimeSeriesInt(invalid, "day", "count"),
+				Challenges:       newTimeSeriesInt(challenges, "day", "count"),
+			}
+
+			// Today only counts for the part of it that has passed
+			elapsed := time.Since(startDate).Hours() / 24
+
+			response.Averages = PocAverages{
+				response.BeaconsSent.Sum / elapsed,
+				response.WitnessesValid.Sum / elapsed,
+				response.WitnessesInvalid.Sum / elapsed,
+				response.Challenges.Sum / elapsed,
+			}
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// pocEvent is a beacon ("challengee"), a challenge ("challenger") or one witness
+// report ("witness") of a hotspot
+type pocEvent struct {
+	Role          string
+	Time          int64
+	Valid         bool
+	InvalidReason string
+}
+
+// getHotspotPocEvents lists the PoC events of the hotspot since the timestamp,
+// oldest first. Every PoC count of the API is built from it.
+func getHotspotPocEvents(address string, since int64) []pocEvent {
+
+	events := make([]pocEvent, 0)
+
+	rows, err := db.DB.Query(`SELECT
+								ta.actor_role,
+								t.time,
+								CASE WHEN ta.actor_role = 'witness' THEN t.fields END
+							FROM
+								transaction_actors AS ta
+								INNER JOIN transactions AS t ON ta.transaction_hash = t.hash
+							WHERE
+								ta.actor = $1
+								AND ta.actor_role IN ('challengee', 'challenger', 'witness')
+								AND t.time >= $2
+							ORDER BY
+								t.time ASC`, address, since)
+	if err != nil {
+		log.Printf("[ERROR getHotspotPocEvents] %v", err)
+		return events
+	}
+
+	defer rows.Close()
+
+	var actorRole, fields sql.NullString
+	var timestamp sql.NullInt64
+
+	for rows.Next() {
+
+		err := rows.Scan(&actorRole, &timestamp, &fields)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+
+		if actorRole.String != "witness" {
+			events = append(events, pocEvent{Role: actorRole.String, Time: timestamp.Int64})
+			continue
+		}
+
+		witnessList := new(WitnessStruct)
+		if err := json.Unmarshal([]byte(fields.String), &witnessList); err != nil {
+			log.Printf("[ERROR getHotspotPocEvents] %v", err)
+			continue
+		}
+
+		for _, path := range witnessList.Path {
+			for _, witness := range path.Witnesses {
+				if witness.Gateway != address {
+					continue
+				}
+
+				events = append(events, pocEvent{"witness", timestamp.Int64, witness.IsValid, witness.InvalidReason})
+			}
+		}
+	}
+
+	return events
+}
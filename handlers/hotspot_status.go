@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// statusMaxBatch is the largest list accepted by POST /hotspots/status/,
+// STATUS_MAX_BATCH (default 500)
+func statusMaxBatch() int {
+
+	return envInt("STATUS_MAX_BATCH", 500)
+}
+
+// uniqueHotspotIDs trims the ids and removes duplicates and empty ids, keeping
+// the submitted order
+func uniqueHotspotIDs(ids []string) []string {
+
+	seen := make(map[string]bool, len(ids))
+	hotspots := make([]string, 0, len(ids))
+	for _, hotspot := range ids {
+		hotspot = strings.TrimSpace(hotspot)
+		if hotspot == "" || seen[hotspot] {
+			continue
+		}
+		seen[hotspot] = true
+		hotspots = append(hotspots, hotspot)
+	}
+
+	return hotspots
+}
+
+func GetMultipleHotspotStatus(c echo.Context) error {
+
+	type payload struct {
+		HotspotIDs     []string `json:"hotspots"`
+		IncludeRewards bool     `json:"include_rewards"`
+	}
+
+	type response struct {
+		HotspotID  string `json:"hotspot_id"`
+		Active     Active `json:"active"`
+		Rewards24H *int64 `json:"rewards_24h,omitempty"`
+	}
+
+	res := new(payload)
+	if err := c.Bind(res); err != nil {
+		log.Printf("%v", err)
+		return err
+	}
+
+	if len(res.HotspotIDs) > statusMaxBatch() {
+		return c.JSON(400, fmt.Sprintf("Too many hotspots, the maximum is %v", statusMaxBatch()))
+	}
+
+	hotspots := uniqueHotspotIDs(res.HotspotIDs)
+
+	statuses := getHotspotStatuses(hotspots)
+
+	var rewards map[string]int64
+	if res.IncludeRewards {
+		rewards = getHotspotsRewardsSince(hotspots, time.Now().Add(-24*time.Hour).Unix())
+	}
+
+	responsePayload := make([]response, 0, len(hotspots))
+	for _, hotspot := range hotspots {
+
+		item := response{HotspotID: hotspot, Active: statuses[hotspot]}
+
+		if res.IncludeRewards {
+			amount := rewards[hotspot]
+			item.Rewards24H = &amount
+		}
+
+		responsePayload = append(responsePayload, item)
+	}
+
+	return c.JSON(200, responsePayload)
+}
+
+// getHotspotStatuses is the batched getHotspotStatus. Cached statuses are read with
+// a single GetMulti, the missing ones are built with one query per table and cached
+// under the same keys as getHotspotStatus.
+func getHotspotStatuses(hotspots []string) map[string]Active {
+
+	statuses := make(map[string]Active, len(hotspots))
+
+	if len(hotspots) == 0 {
+		return statuses
+	}
+
+	cacheNames := make([]string, 0, len(hotspots))
+	for _, hotspot := range hotspots {
+		cacheNames = append(cacheNames, fmt.Sprintf("hotspot-status-%v", hotspot))
+	}
+
+	cacheData, err := db.MC.GetMulti(cacheNames)
+	if err != nil {
+		log.Printf("[ERROR getHotspotStatuses] %v", err)
+	}
+
+	missing := make([]string, 0)
+	for _, hotspot := range hotspots {
+
+		item, ok := cacheData[fmt.Sprintf("hotspot-status-%v", hotspot)]
+		if !ok {
+			missing = append(missing, hotspot)
+			continue
+		}
+
+		var activity Active
+		dec := gob.NewDecoder(bytes.NewBuffer(item.Value))
+		if err := dec.Decode(&activity); err != nil {
+			log.Println("Error decode: ", err)
+			missing = append(missing, hotspot)
+			continue
+		}
+
+		statuses[hotspot] = activity
+	}
+
+	if len(missing) == 0 {
+		return statuses
+	}
+
+	// Last activity per hotspot
+	type lastActivity struct {
+		block     int64
+		tx        string
+		timestamp int64
+	}
+
+	activities := make(map[string]lastActivity, len(missing))
+	blocks := make([]int64, 0, len(missing))
+
+	// One index lookup per hotspot, like the LIMIT 1 query of getHotspotStatus
+	rows, err := db.DB.Query(`SELECT
+								a.actor,
+								ta.block,
+								ta.transaction_hash
+							FROM
+								unnest($1::text[]) a(actor)
+								CROSS JOIN LATERAL (
+									SELECT
+										block,
+										transaction_hash
+									FROM
+										transaction_actors
+									WHERE
+										actor = a.actor
+									ORDER BY
+										block DESC
+									LIMIT 1
+								) ta`, pq.Array(missing))
+	if err != nil {
+		log.Printf("[ERROR getHotspotStatuses A] %v", err)
+	} else {
+
+		var actor, tx sql.NullString
+		var block sql.NullInt64
+
+		for rows.Next() {
+
+			err := rows.Scan(&actor, &block, &tx)
+			if err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+
+			activities[actor.String] = lastActivity{block.Int64, tx.String, 0}
+			blocks = append(blocks, block.Int64)
+		}
+		rows.Close()
+	}
+
+	blockTimes := make(map[int64]int64, len(blocks))
+
+	rows, err = db.DB.Query(`SELECT height, time FROM blocks WHERE height = ANY($1)`, pq.Array(blocks))
+	if err != nil {
+		log.Printf("[ERROR getHotspotStatuses B] %v", err)
+	} else {
+
+		var height, timestamp sql.NullInt64
+
+		for rows.Next() {
+
+			err := rows.Scan(&height, &timestamp)
+			if err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+
+			blockTimes[height.Int64] = timestamp.Int64
+		}
+		rows.Close()
+	}
+
+	// p2p state per hotspot
+	relays := make(map[string]HealthRelay, len(missing))
+
+	rows, err = db.DB.Query(`SELECT address, online, peer_timestamp, listen_addrs FROM gateway_status WHERE address = ANY($1)`, pq.Array(missing))
+	if err != nil {
+		log.Printf("[ERROR getHotspotStatuses C] %v", err)
+	} else {
+
+		var address, online, peerTimestamp, listenAddrs sql.NullString
+
+		for rows.Next() {
+
+			err := rows.Scan(&address, &online, &peerTimestamp, &listenAddrs)
+			if err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+
+			relays[address.String] = newHealthRelay(online.String, peerTimestamp.String, listenAddrs.String)
+		}
+		rows.Close()
+	}
+
+	for _, hotspot := range missing {
+
+		relay, ok := relays[hotspot]
+		if !ok {
+			relay = HealthRelay{ListenAddrs: make([]string, 0)}
+		}
+
+		activity := buildHotspotStatus(0, "none", relay)
+		if last, ok := activities[hotspot]; ok && blockTimes[last.block] != 0 {
+			activity = buildHotspotStatus(blockTimes[last.block], last.tx, relay)
+		}
+
+		statuses[hotspot] = activity
+
+		var delayTime int32 = 60
+		if activity.Active {
+			delayTime = 60 * 10
+		}
+
+		var buf bytes.Buffer
+		enc := gob.NewEncoder(&buf)
+		if err := enc.Encode(activity); err != nil {
+			log.Println("Error gob: ", err)
+		}
+
+		db.MC.Set(&memcache.Item{Key: fmt.Sprintf("hotspot-status-%v", hotspot), Value: buf.Bytes(), Expiration: delayTime})
+	}
+
+	return statuses
+}
+
+// getHotspotsRewardsSince sums the rewards per hotspot since the given time
+func getHotspotsRewardsSince(hotspots []string, since int64) map[string]int64 {
+
+	rewards := make(map[string]int64, len(hotspots))
+
+	rows, err := db.DB.Query(`SELECT gateway, SUM(amount) FROM rewards WHERE gateway = ANY($1) AND time >= $2 GROUP BY gateway`, pq.Array(hotspots), since)
+	if err != nil {
+		log.Printf("[ERROR getHotspotsRewardsSince] %v", err)
+		return rewards
+	}
+
+	defer rows.Close()
+
+	var gateway sql.NullString
+	var amount sql.NullInt64
+
+	for rows.Next() {
+
+		err := rows.Scan(&gateway, &amount)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+
+		rewards[gateway.String] = amount.Int64
+	}
+
+	return rewards
+}
@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"database/sql"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"hntscan/db"
 	"log"
+	"math"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,6 +23,33 @@ import (
 	"github.com/uber/h3-go/v3"
 )
 
+// Optional joins of the hotspot list, added when a filter or the sort needs them
+const hotspotListJoinStatus = " LEFT JOIN gateway_status gs ON gs.address = h.address"
+const hotspotListJoinRewards = " LEFT JOIN hotspot_reward_totals r ON r.address = h.address"
+
+// Sort options for the hotspot list, the column they order by and its join.
+// Rewards are the 30 day totals precomputed by the leaderboard job.
+var hotspotListSorts = map[string][2]string{
+	"first_block":  {"h.first_block", ""},
+	"name":         {"h.name", ""},
+	"owner":        {"h.owner", ""},
+	"payer":        {"h.payer", ""},
+	"mode":         {"h.mode", ""},
+	"reward_scale": {"h.reward_scale", ""},
+	"elevation":    {"h.elevation", ""},
+	"gain":         {"h.gain", ""},
+	"country":      {"l.long_country", ""},
+	"city":         {"l.long_city", ""},
+	"online":       {"gs.online", hotspotListJoinStatus},
+	"rewards":      {"r.rewards_30d", hotspotListJoinRewards},
+}
+
+var hotspotListModes = map[string]bool{
+	"full":     true,
+	"light":    true,
+	"dataonly": true,
+}
+
 func GetHotspots(c echo.Context) error {
 
 	var hotspots []Hotspot
@@ -37,15 +68,22 @@ func GetHotspots(c echo.Context) error {
 	limit := 25
 	offset = offset * limit
 
+	where, joins, args, orderBy, cacheKey, err := parseHotspotListQuery(c)
+	if err != nil {
+		return c.JSON(400, "Bad request")
+	}
+
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 
-	cacheName := fmt.Sprintf("hotspots-%v", offset)
+	cacheName := fmt.Sprintf("hotspots-%v-%v", cacheKey, offset)
 	cacheData, err := db.MC.Get(cacheName)
 	if err != nil {
 
 		if err == memcache.ErrCacheMiss {
 
+			args = append(args, limit, offset)
+
 			rows, err := db.DB.Query(`SELECT
 										h.address,
 										h.name,
@@ -65,12 +103,13 @@ func GetHotspots(c echo.Context) error {
 										l.short_country
 									FROM
 										gateway_inventory h
-										INNER JOIN locations l ON l.location = h.location
+										INNER JOIN locations l ON l.location = h.location`+joins+where+`
 									ORDER BY
-										h.first_block DESC
-									LIMIT $1 OFFSET $2`, limit, offset)
+										`+orderBy+`
+									LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)), args...)
 			if err != nil {
-				log.Println(err)
+				log.Printf("[ERROR GetHotspots] %v", err)
+				return c.JSON(500, "Internal server error")
 			}
 
 			var lastPocChallenge, firstBlock, lastBlock, nonce, elevation, gain sql.NullInt64
@@ -117,6 +156,7 @@ func GetHotspots(c echo.Context) error {
 					active.Active,
 					active.Timestamp,
 					active.TX,
+					false, // set by markDenylisted after the cache read
 				})
 
 			}
@@ -137,9 +177,136 @@ func GetHotspots(c echo.Context) error {
 		}
 	}
 
+	// The denylist reloads independently of the cache
+	markDenylisted(hotspots)
+
 	return c.JSON(200, hotspots)
 }
 
+// parseHotspotListQuery turns the list filters into a WHERE clause with its
+// arguments, the joins they need, the ORDER BY clause and a cache key covering
+// every combination
+func parseHotspotListQuery(c echo.Context) (string, string, []interface{}, string, string, error) {
+
+	var conditions []string
+	var args []interface{}
+	joins := ""
+
+	// Normalized copy of the accepted parameters, used for the cache key
+	normalized := url.Values{}
+
+	addCondition := func(param string, condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+		normalized.Set(param, fmt.Sprintf("%v", value))
+	}
+
+	// Exact match filters on indexed columns
+	exactFilters := []struct {
+		param     string
+		condition string
+	}{
+		{"country", "l.short_country = $%v"},
+		{"state", "l.short_state = $%v"},
+		{"city", "l.city_id = $%v"},
+		{"owner", "h.owner = $%v"},
+		{"payer", "h.payer = $%v"},
+		{"maker", "h.payer IN (SELECT address FROM makers WHERE LOWER(name) = LOWER($%v))"},
+	}
+
+	for _, filter := range exactFilters {
+		if value := c.QueryParam(filter.param); value != "" {
+			addCondition(filter.param, filter.condition, value)
+		}
+	}
+
+	if mode := c.QueryParam("mode"); mode != "" {
+		if !hotspotListModes[mode] {
+			return "", "", nil, "", "", fmt.Errorf("invalid mode %v", mode)
+		}
+		addCondition("mode", "h.mode = $%v", mode)
+	}
+
+	if online := c.QueryParam("online"); online != "" {
+		isOnline, err := strconv.ParseBool(online)
+		if err != nil {
+			return "", "", nil, "", "", err
+		}
+		if isOnline {
+			addCondition("online", "gs.online = $%v", "online")
+		} else {
+			addCondition("online", "gs.online IS DISTINCT FROM $%v", "online")
+		}
+		joins += hotspotListJoinStatus
+	}
+
+	// Range filters
+	rangeFilters := []struct {
+		param     string
+		condition string
+	}{
+		{"min_reward_scale", "h.reward_scale >= $%v"},
+		{"max_reward_scale", "h.reward_scale <= $%v"},
+		{"min_elevation", "h.elevation >= $%v"},
+		{"max_elevation", "h.elevation <= $%v"},
+		{"min_gain", "h.gain >= $%v"},
+		{"max_gain", "h.gain <= $%v"},
+	}
+
+	for _, filter := range rangeFilters {
+		if value := c.QueryParam(filter.param); value != "" {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return "", "", nil, "", "", err
+			}
+			addCondition(filter.param, filter.condition, number)
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = `
+									WHERE
+										` + strings.Join(conditions, `
+										AND `)
+	}
+
+	sortBy := c.QueryParam("sort")
+	if sortBy == "" {
+		sortBy = "first_block"
+	}
+
+	sortColumn, ok := hotspotListSorts[sortBy]
+	if !ok {
+		return "", "", nil, "", "", fmt.Errorf("invalid sort %v", sortBy)
+	}
+
+	order := strings.ToUpper(c.QueryParam("order"))
+	if order == "" {
+		order = "DESC"
+	}
+
+	if order != "ASC" && order != "DESC" {
+		return "", "", nil, "", "", fmt.Errorf("invalid order %v", order)
+	}
+
+	normalized.Set("sort", sortBy)
+	normalized.Set("order", order)
+
+	column, join := sortColumn[0], sortColumn[1]
+	if join != "" && !strings.Contains(joins, join) {
+		joins += join
+	}
+
+	orderBy := fmt.Sprintf("%v %v NULLS LAST, h.address", column, order)
+
+	// Encode sorts the keys, so equal filters always give the same key
+	hash := sha1.Sum([]byte(normalized.Encode()))
+	cacheKey := hex.EncodeToString(hash[:])
+
+	return where, joins, args, orderBy, cacheKey, nil
+}
+
 func GetSingleHotspot(c echo.Context) error {
 
 	hotspots := make([]SingleHotspot, 0)
@@ -219,6 +386,7 @@ func GetSingleHotspot(c echo.Context) error {
 					active.Active,
 					active.Timestamp,
 					active.TX,
+					false, // set by markDenylisted after the cache read
 				})
 			}
 
@@ -238,6 +406,9 @@ func GetSingleHotspot(c echo.Context) error {
 		}
 	}
 
+	// The denylist reloads independently of the cache
+	markDenylisted(hotspots)
+
 	return c.JSON(200, hotspots)
 }
 
@@ -273,9 +444,11 @@ func GetSingleHotspotRewards(c echo.Context) error {
 		return c.JSON(400, "Bad request")
 	}
 
+	maxDays, _ := rewardMaxSpan("day")
+
 	daysInt, err := strconv.Atoi(days)
-	if err != nil {
-		log.Println(err)
+	if err != nil || daysInt <= 0 || daysInt > maxDays {
+		return c.JSON(400, "Bad request")
 	}
 
 	rewards := getSingleHotspotRewards(hash, daysInt)
@@ -286,7 +459,9 @@ func GetSingleHotspotRewards(c echo.Context) error {
 		intDay = 30
 	}
 
-	payload := Reward{intDay, rewards, rewards24h}
+	rewardTypes := getRewardsByType("gateway", hash, intDay)
+
+	payload := Reward{intDay, rewards, rewards24h, rewardTypes}
 
 	return c.JSON(200, payload)
 }
@@ -322,7 +497,7 @@ func GetSingleHotspot24Rewards(hash string) map[int64]int64 {
 					log.Printf("[ERROR] %v", err)
 				}
 
-				rewards[time.Int64] = amount.Int64
+				rewards[time.Int64] += amount.Int64
 			}
 
 			if err := enc.Encode(rewards); err != nil {
@@ -354,41 +529,14 @@ func GetSingleHotspotStatus(c echo.Context) error {
 	return c.JSON(200, status)
 }
 
-func GetMultipleHotspotStatus(c echo.Context) error {
-
-	type payload struct {
-		HotspotIDs []string `json:"hotspots"`
-	}
-
-	type response struct {
-		HotspotID string `json:"hotspot_id"`
-		Active    Active `json:"active"`
-	}
-
-	var responsePayload []response
-
-	res := new(payload)
-	if err := c.Bind(res); err != nil {
-		log.Printf("%v", err)
-		return err
-	}
-
-	for _, hotspot := range res.HotspotIDs {
-		status := getHotspotStatus(hotspot)
-		responsePayload = append(responsePayload, response{hotspot, status})
-	}
-
-	return c.JSON(200, responsePayload)
-
-}
-
 func GetSingleHotspotAvgBeacons(c echo.Context) error {
 
 	hash := c.Param("hash")
 
-	sevenDayBeacon := getSingleHotspotBeacons(hash)
+	activity := getHotspotPocActivity(hash, 7)
 
-	return c.JSON(200, SevenDayAvgBeacons{sevenDayBeacon})
+	// v1 has always reported a whole number of beacons
+	return c.JSON(200, SevenDayAvgBeacons{int(math.Round(activity.Averages.Beacons))})
 }
 
 func HotspotTrend30Days() HotspotTrend {
@@ -932,95 +1080,6 @@ func getSingleHotspotActivities(address string, limit int, offset int) ActivityR
 	return response
 }
 
-func getSingleHotspotBeacons(address string) int {
-
-	responseData := 0
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-
-	cacheName := fmt.Sprintf("hotspot-activity-7days-%v", address)
-	cacheData, err := db.MC.Get(cacheName)
-
-	if err != nil {
-		if err == memcache.ErrCacheMiss {
-
-			sevenDaysAgo := time.Now().AddDate(0, 0, -7)
-
-			rows, err := db.DB.Query(`SELECT
-										ta.actor_role,
-										t.type,
-										t.hash,
-										t.time,
-										t.block,
-										t.fields
-									FROM
-										transaction_actors AS ta
-										INNER JOIN transactions AS t ON ta.transaction_hash = t.hash
-									WHERE
-										actor = $1
-										AND t.time > $2
-									ORDER BY
-										block`, address, sevenDaysAgo.Unix())
-
-			if err != nil {
-				log.Printf("[ERROR]7 %v", err)
-			}
-
-			defer rows.Close()
-
-			var actor_role, txType, hash, fields sql.NullString
-			var timeField, block sql.NullInt64
-
-			// witnessCount := 0
-			beaconCount := 0
-
-			for rows.Next() {
-
-				err := rows.Scan(&actor_role, &txType, &hash, &timeField, &block, &fields)
-				if err != nil {
-					log.Printf("[ERROR] %v", err)
-				}
-
-				// Challengee
-				if actor_role.String == "challengee" {
-					beaconCount++
-				}
-
-				// Challenger
-				if actor_role.String == "challenger" {
-					beaconCount++
-				}
-
-				// Witness Data Parsing (This hotspot receied a beacon from someone else)
-				if actor_role.String == "witness" {
-					beaconCount++
-				}
-
-			}
-
-			responseData = beaconCount
-
-			if err := enc.Encode(responseData); err != nil {
-				log.Println("Error gob: ", err)
-			}
-
-			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 60 * 60 * 24})
-
-		}
-	} else {
-
-		bufDecode := bytes.NewBuffer(cacheData.Value)
-		dec := gob.NewDecoder(bufDecode)
-
-		if err := dec.Decode(&responseData); err != nil {
-			log.Println("Error decode: ", err)
-		}
-
-	}
-
-	return responseData
-}
-
 // getHotspotData returns a single hotspot data (cached)
 func getHotspotData(hash string) []HotspotStruct {
 
@@ -1111,6 +1170,11 @@ func getHotspotData(hash string) []HotspotStruct {
 				active.Active,
 				active.Timestamp,
 				active.TX,
+				active.Online,
+				active.Relayed,
+				active.PeerTimestamp,
+				active.Status,
+				false, // set by markDenylisted after the cache read
 			})
 
 			if err := enc.Encode(returnStruct); err != nil {
@@ -1131,9 +1195,31 @@ func getHotspotData(hash string) []HotspotStruct {
 
 	}
 
+	// The status is cached for less time and the denylist reloads on its own,
+	// both are refreshed after the cache read
+	for i := range returnStruct {
+		if returnStruct[i].Address != "" {
+			returnStruct[i].setStatus(getHotspotStatus(returnStruct[i].Address))
+		}
+	}
+	markDenylisted(returnStruct)
+
 	return returnStruct
 }
 
+// setStatus copies every activity and p2p field of the status, so they can't
+// contradict each other
+func (h *HotspotStruct) setStatus(active Active) {
+
+	h.Active = active.Active
+	h.ActivityTimestamp = active.Timestamp
+	h.ActivityTX = active.TX
+	h.Online = active.Online
+	h.Relayed = active.Relayed
+	h.PeerTimestamp = active.PeerTimestamp
+	h.Status = active.Status
+}
+
 func calculatePlace(location string) string {
 
 	g := getGeolocationData(location)
@@ -1184,24 +1270,32 @@ func getGeolocationData(location string) GeoCode {
 
 			if err != nil {
 				if err == sql.ErrNoRows {
-					// Return empty struct
-					return GeoCode{"", "", "", "", "", "", "", "", ""}
+
+					// Fall back to the offline reverse geocoder
+					geocoded, ok := reverseGeocode(location)
+					if !ok {
+						// Return empty struct
+						return GeoCode{"", "", "", "", "", "", "", "", ""}
+					}
+
+					newGeoCode = geocoded
 
 				} else {
 					log.Printf("[ERROR]11 error: %v ", err)
 				}
-			}
+			} else {
 
-			newGeoCode = GeoCode{
-				short_street.String,
-				short_state.String,
-				short_country.String,
-				short_city.String,
-				long_street.String,
-				long_state.String,
-				long_country.String,
-				long_city.String,
-				city_id.String,
+				newGeoCode = GeoCode{
+					short_street.String,
+					short_state.String,
+					short_country.String,
+					short_city.String,
+					long_street.String,
+					long_state.String,
+					long_country.String,
+					long_city.String,
+					city_id.String,
+				}
 			}
 
 			if err := enc.Encode(newGeoCode); err != nil {
@@ -1254,7 +1348,7 @@ func getSingleHotspotRewards(hash string, days int) map[int64]int64 {
 				query.Scan(&block, &timestamp, &amount, &txType)
 
 				if amount.Valid {
-					rewards[timestamp.Int64] = amount.Int64
+					rewards[timestamp.Int64] += amount.Int64
 				}
 			}
 
@@ -1720,6 +1814,7 @@ func getHotspotDataByName(query string) []HotspotSearch {
 						rewardScale.Float64,
 						elevation.Int64,
 						gain.Int64,
+						false, // set by markDenylisted after the cache read
 					})
 
 				}
@@ -1742,12 +1837,52 @@ func getHotspotDataByName(query string) []HotspotSearch {
 		}
 	}
 
+	// The denylist reloads independently of the cache
+	markDenylisted(hotspots)
+
 	return hotspots
 }
 
+// Status thresholds in hours, STATUS_ACTIVE_HOURS and STATUS_PEER_STALE_HOURS
+func statusThresholds() (int64, int64) {
+
+	return int64(envInt("STATUS_ACTIVE_HOURS", 36)), int64(envInt("STATUS_PEER_STALE_HOURS", 24))
+}
+
+// buildHotspotStatus combines the chain activity with the p2p state. Status is
+// "online", "inactive" (p2p online without recent activity), "stale" (old peer
+// entry) or "offline".
+func buildHotspotStatus(timestamp int64, tx string, relay HealthRelay) Active {
+
+	activeHours, peerStaleHours := statusThresholds()
+	now := time.Now().Unix()
+
+	activity := Active{
+		Active:        timestamp != 0 && now-timestamp < activeHours*60*60,
+		Timestamp:     timestamp,
+		TX:            tx,
+		Online:        relay.Online,
+		Relayed:       relay.Relayed,
+		PeerTimestamp: relay.PeerTimestamp,
+	}
+
+	switch {
+	case !relay.Online:
+		activity.Status = "offline"
+	case relay.PeerTimestamp == 0 || now-relay.PeerTimestamp > peerStaleHours*60*60:
+		activity.Status = "stale"
+	case !activity.Active:
+		activity.Status = "inactive"
+	default:
+		activity.Status = "online"
+	}
+
+	return activity
+}
+
 func getHotspotStatus(hash string) Active {
 
-	activity := Active{false, 0, "none"}
+	activity := Active{TX: "none", Status: "offline"}
 
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -1767,22 +1902,20 @@ func getHotspotStatus(hash string) Active {
 
 			row.Scan(&block, &tx)
 
+			var timestamp sql.NullInt64
 			if block.Valid && block.Int64 > 0 {
-
 				row := db.DB.QueryRow(`SELECT time FROM blocks WHERE height = $1`, block.Int64)
-				var timestamp sql.NullInt64
 				row.Scan(&timestamp)
+			}
 
-				if timestamp.Valid && timestamp.Int64 != 0 {
-					// Check if delta is less than 36 hours
-					delta := time.Now().Unix() - timestamp.Int64
-					if delta < 36*60*60 {
-						delayTime = 60 * 10 // in case true, increase delay time to 10 minutes
-						activity = Active{true, timestamp.Int64, tx.String}
-					} else {
-						activity = Active{false, timestamp.Int64, tx.String}
-					}
-				}
+			if timestamp.Int64 != 0 {
+				activity = buildHotspotStatus(timestamp.Int64, tx.String, getHotspotRelayStatus(hash))
+			} else {
+				activity = buildHotspotStatus(0, "none", getHotspotRelayStatus(hash))
+			}
+
+			if activity.Active {
+				delayTime = 60 * 10 // in case true, increase delay time to 10 minutes
 			}
 
 			if err := enc.Encode(activity); err != nil {
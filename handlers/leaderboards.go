@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+// Periods (in days) the leaderboards are precomputed for
+var leaderboardPeriods = []int{1, 7, 30}
+
+// Place levels and the locations columns they group on
+var leaderboardLevels = map[string][2]string{
+	"countries": {"l.short_country", "l.long_country"},
+	"cities":    {"l.city_id", "l.long_city"},
+}
+
+// Sort options and the place_leaderboards column they order by
+var leaderboardSorts = map[string]string{
+	"hotspots":            "hotspots",
+	"growth":              "growth",
+	"online":              "online_percentage",
+	"rewards":             "rewards",
+	"rewards_per_hotspot": "rewards_per_hotspot",
+}
+
+// StartLeaderboardJob creates the place_leaderboards table and recomputes it on an
+// interval (LEADERBOARD_REFRESH_MINUTES, defaults to 60 minutes)
+func StartLeaderboardJob() {
+
+	_, err := db.DB.Exec(`CREATE TABLE IF NOT EXISTS place_leaderboards (
+							level TEXT NOT NULL,
+							place_id TEXT NOT NULL,
+							name TEXT,
+							short_country TEXT,
+							period INTEGER NOT NULL,
+							hotspots BIGINT,
+							growth BIGINT,
+							online BIGINT,
+							online_percentage DOUBLE PRECISION,
+							rewards BIGINT,
+							rewards_per_hotspot DOUBLE PRECISION,
+							updated_at BIGINT,
+							PRIMARY KEY (level, period, place_id)
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartLeaderboardJob] %v", err)
+		return
+	}
+
+	// 30 day rewards per hotspot, the hotspot list sorts on them
+	_, err = db.DB.Exec(`CREATE TABLE IF NOT EXISTS hotspot_reward_totals (
+							address TEXT PRIMARY KEY,
+							rewards_30d BIGINT,
+							updated_at BIGINT
+						)`)
+	if err != nil {
+		log.Printf("[ERROR StartLeaderboardJob] %v", err)
+		return
+	}
+
+	interval := envInt("LEADERBOARD_REFRESH_MINUTES", 60)
+
+	go func() {
+		for {
+			computeHotspotRewardTotals()
+			for level := range leaderboardLevels {
+				for _, period := range leaderboardPeriods {
+					computeLeaderboard(level, period)
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Minute)
+		}
+	}()
+}
+
+func computeHotspotRewardTotals() {
+
+	start := time.Now()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("[ERROR computeHotspotRewardTotals] %v", err)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM hotspot_reward_totals`)
+	if err != nil {
+		log.Printf("[ERROR computeHotspotRewardTotals] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	_, err = tx.Exec(`INSERT INTO hotspot_reward_totals
+						SELECT
+							gateway,
+							SUM(amount),
+							$2
+						FROM
+							rewards
+						WHERE
+							time >= $1
+							AND gateway IS NOT NULL
+						GROUP BY
+							gateway`, start.AddDate(0, 0, -30).Unix(), start.Unix())
+	if err != nil {
+		log.Printf("[ERROR computeHotspotRewardTotals] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[ERROR computeHotspotRewardTotals] %v", err)
+		return
+	}
+
+	log.Printf("Hotspot reward totals computed in %v", time.Since(start))
+}
+
+func computeLeaderboard(level string, period int) {
+
+	start := time.Now()
+	columns := leaderboardLevels[level]
+
+	periodStart := time.Now().AddDate(0, 0, -period)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("[ERROR computeLeaderboard] %v", err)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM place_leaderboards WHERE level = $1 AND period = $2`, level, period)
+	if err != nil {
+		log.Printf("[ERROR computeLeaderboard] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	// columns come from leaderboardLevels, never from user input
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO place_leaderboards
+									SELECT
+										$1,
+										%[1]v,
+										MAX(%[2]v),
+										MAX(l.short_country),
+										$2,
+										COUNT(*),
+										COUNT(*) FILTER (WHERE gi.first_timestamp >= $3),
+										COUNT(*) FILTER (WHERE gs.online = 'online'),
+										COUNT(*) FILTER (WHERE gs.online = 'online')::DOUBLE PRECISION / COUNT(*) * 100,
+										COALESCE(SUM(r.amount), 0),
+										COALESCE(SUM(r.amount), 0)::DOUBLE PRECISION / COUNT(*),
+										$4
+									FROM
+										gateway_inventory gi
+										INNER JOIN locations l ON l.location = gi.location
+										LEFT JOIN gateway_status gs ON gs.address = gi.address
+										LEFT JOIN (
+											SELECT gateway, SUM(amount) AS amount FROM rewards WHERE time >= $5 GROUP BY gateway
+										) r ON r.gateway = gi.address
+									WHERE
+										%[1]v IS NOT NULL
+										AND %[1]v != ''
+									GROUP BY
+										%[1]v`, columns[0], columns[1]),
+		level, period, periodStart.Format(time.RFC3339), time.Now().Unix(), periodStart.Unix())
+	if err != nil {
+		log.Printf("[ERROR computeLeaderboard] %v", err)
+		tx.Rollback()
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[ERROR computeLeaderboard] %v", err)
+		return
+	}
+
+	log.Printf("Leaderboard %v/%vd computed in %v", level, period, time.Since(start))
+}
+
+func GetPlaceLeaderboard(c echo.Context) error {
+
+	level := c.Param("level")
+	if _, ok := leaderboardLevels[level]; !ok {
+		return c.JSON(400, "Bad request")
+	}
+
+	sortBy := c.QueryParam("sort")
+	if sortBy == "" {
+		sortBy = "hotspots"
+	}
+
+	if _, ok := leaderboardSorts[sortBy]; !ok {
+		return c.JSON(400, "Bad request")
+	}
+
+	period := 30
+	if c.QueryParam("period") != "" {
+
+		p, err := strconv.Atoi(c.QueryParam("period"))
+		if err != nil {
+			return c.JSON(400, "Bad request")
+		}
+
+		valid := false
+		for _, v := range leaderboardPeriods {
+			if v == p {
+				valid = true
+			}
+		}
+
+		if !valid {
+			return c.JSON(400, "Bad request")
+		}
+
+		period = p
+	}
+
+	page := c.QueryParam("page")
+
+	if page == "" {
+		page = "0"
+	}
+
+	offset, err := strconv.Atoi(page)
+	if err != nil {
+		log.Println(err)
+	}
+
+	limit := 25
+	offset = offset * limit
+
+	leaderboard := getPlaceLeaderboard(level, sortBy, period, limit, offset)
+
+	return c.JSON(200, leaderboard)
+}
+
+func getPlaceLeaderboard(level string, sortBy string, period int, limit int, offset int) []PlaceLeaderboard {
+
+	leaderboard := make([]PlaceLeaderboard, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("leaderboard-%v-%v-%v-%v", level, sortBy, period, offset)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			// sort column comes from leaderboardSorts, never from user input
+			rows, err := db.DB.Query(fmt.Sprintf(`SELECT
+													place_id,
+													name,
+													short_country,
+													hotspots,
+													growth,
+													online,
+													online_percentage,
+													rewards,
+													rewards_per_hotspot,
+													updated_at
+												FROM
+													place_leaderboards
+												WHERE
+													level = $1
+													AND period = $2
+												ORDER BY
+													%v DESC, place_id
+												LIMIT $3 OFFSET $4`, leaderboardSorts[sortBy]), level, period, limit, offset)
+			if err != nil {
+				log.Printf("[ERROR getPlaceLeaderboard] %v", err)
+				return leaderboard
+			}
+
+			defer rows.Close()
+
+			var placeID, name, shortCountry sql.NullString
+			var hotspots, growth, online, rewards, updatedAt sql.NullInt64
+			var onlinePercentage, rewardsPerHotspot sql.NullFloat64
+
+			rank := offset
+			for rows.Next() {
+
+				err := rows.Scan(&placeID, &name, &shortCountry, &hotspots, &growth, &online, &onlinePercentage, &rewards, &rewardsPerHotspot, &updatedAt)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				rank++
+
+				leaderboard = append(leaderboard, PlaceLeaderboard{
+					rank,
+					placeID.String,
+					name.String,
+					shortCountry.String,
+					period,
+					hotspots.Int64,
+					growth.Int64,
+					online.Int64,
+					onlinePercentage.Float64,
+					rewards.Int64,
+					rewardsPerHotspot.Float64,
+					updatedAt.Int64,
+				})
+			}
+			rows.Close()
+
+			if err := enc.Encode(leaderboard); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&leaderboard); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return leaderboard
+}
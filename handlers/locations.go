@@ -0,0 +1,626 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+func GetCountries(c echo.Context) error {
+
+	countries := getCountryList()
+
+	return c.JSON(200, countries)
+}
+
+func GetCountryCities(c echo.Context) error {
+
+	code := c.Param("code")
+
+	if code == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	// Optional state filter (short_state)
+	state := c.QueryParam("state")
+
+	cities := getCityList(code, state)
+
+	return c.JSON(200, cities)
+}
+
+func GetCityHotspots(c echo.Context) error {
+
+	cityID := c.Param("city_id")
+
+	if cityID == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	page := c.QueryParam("page")
+
+	if page == "" {
+		page = "0"
+	}
+
+	offset, err := strconv.Atoi(page)
+	if err != nil {
+		log.Println(err)
+	}
+
+	limit := 25
+	offset = offset * limit
+
+	city := getCity(cityID)
+	city.Hotspots = getCityHotspots(cityID, limit, offset)
+
+	return c.JSON(200, city)
+}
+
+func getCountryList() []PlaceStats {
+
+	countries := make([]PlaceStats, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := "locations-countries"
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			rows, err := db.DB.Query(`SELECT
+										l.short_country,
+										l.long_country,
+										COUNT(*) AS hotspots,
+										COUNT(*) FILTER (WHERE gs.online = 'online') AS online
+									FROM
+										gateway_inventory gi
+										INNER JOIN locations l ON l.location = gi.location
+										LEFT JOIN gateway_status gs ON gs.address = gi.address
+									GROUP BY
+										l.short_country,
+										l.long_country
+									ORDER BY
+										hotspots DESC`)
+			if err != nil {
+				log.Printf("[ERROR getCountryList] %v", err)
+				return countries
+			}
+
+			defer rows.Close()
+
+			var shortCountry, longCountry sql.NullString
+			var hotspots, online sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&shortCountry, &longCountry, &hotspots, &online)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				countries = append(countries, PlaceStats{
+					DataType:     "country",
+					ID:           shortCountry.String,
+					Name:         longCountry.String,
+					ShortCountry: shortCountry.String,
+					Country:      longCountry.String,
+					Hotspots:     hotspots.Int64,
+					Online:       online.Int64,
+				})
+			}
+			rows.Close()
+
+			rewards := getPlaceRewards("l.short_country", "", "")
+			for i := range countries {
+				countries[i].Rewards30D = rewards[countries[i].ID]
+			}
+
+			if err := enc.Encode(countries); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&countries); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return countries
+}
+
+func getCityList(code string, state string) []PlaceStats {
+
+	cities := make([]PlaceStats, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("locations-cities-%v-%v", code, state)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			rows, err := db.DB.Query(`SELECT
+										l.city_id,
+										l.long_city,
+										l.long_state,
+										l.short_country,
+										l.long_country,
+										COUNT(*) AS hotspots,
+										COUNT(*) FILTER (WHERE gs.online = 'online') AS online
+									FROM
+										gateway_inventory gi
+										INNER JOIN locations l ON l.location = gi.location
+										LEFT JOIN gateway_status gs ON gs.address = gi.address
+									WHERE
+										l.short_country = $1
+										AND ($2 = '' OR l.short_state = $2)
+										AND l.city_id IS NOT NULL
+										AND l.city_id != ''
+									GROUP BY
+										l.city_id,
+										l.long_city,
+										l.long_state,
+										l.short_country,
+										l.long_country
+									ORDER BY
+										hotspots DESC`, code, state)
+			if err != nil {
+				log.Printf("[ERROR getCityList] %v", err)
+				return cities
+			}
+
+			defer rows.Close()
+
+			var cityID, longCity, longState, shortCountry, longCountry sql.NullString
+			var hotspots, online sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&cityID, &longCity, &longState, &shortCountry, &longCountry, &hotspots, &online)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				cities = append(cities, PlaceStats{
+					DataType:     "city",
+					ID:           cityID.String,
+					Name:         longCity.String,
+					State:        longState.String,
+					ShortCountry: shortCountry.String,
+					Country:      longCountry.String,
+					Hotspots:     hotspots.Int64,
+					Online:       online.Int64,
+				})
+			}
+			rows.Close()
+
+			rewards := getPlaceRewards("l.city_id", "l.short_country", code)
+			for i := range cities {
+				cities[i].Rewards30D = rewards[cities[i].ID]
+			}
+
+			if err := enc.Encode(cities); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&cities); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return cities
+}
+
+func getCity(cityID string) CityHotspots {
+
+	var city CityHotspots
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("locations-city-%v", cityID)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			row := db.DB.QueryRow(`SELECT
+									MAX(l.long_city),
+									MAX(l.long_state),
+									MAX(l.short_country),
+									MAX(l.long_country),
+									COUNT(*) AS hotspots,
+									COUNT(*) FILTER (WHERE gs.online = 'online') AS online
+								FROM
+									gateway_inventory gi
+									INNER JOIN locations l ON l.location = gi.location
+									LEFT JOIN gateway_status gs ON gs.address = gi.address
+								WHERE
+									l.city_id = $1`, cityID)
+
+			var longCity, longState, shortCountry, longCountry sql.NullString
+			var hotspots, online sql.NullInt64
+
+			err := row.Scan(&longCity, &longState, &shortCountry, &longCountry, &hotspots, &online)
+			if err != nil {
+				log.Printf("[ERROR getCity] %v", err)
+			}
+
+			rewards := getPlaceRewards("l.city_id", "l.city_id", cityID)
+
+			city = CityHotspots{
+				PlaceStats{
+					DataType:     "city",
+					ID:           cityID,
+					Name:         longCity.String,
+					State:        longState.String,
+					ShortCountry: shortCountry.String,
+					Country:      longCountry.String,
+					Hotspots:     hotspots.Int64,
+					Online:       online.Int64,
+					Rewards30D:   rewards[cityID],
+				},
+				nil,
+			}
+
+			if err := enc.Encode(city); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&city); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return city
+}
+
+// getPlaceRewards sums the last 30 days of rewards per place. groupColumn and
+// filterColumn are always one of the locations columns, never user input.
+func getPlaceRewards(groupColumn string, filterColumn string, filterValue string) map[string]int64 {
+
+	rewards := make(map[string]int64, 0)
+
+	startTimestamp := time.Now().AddDate(0, 0, -30).Unix()
+
+	query := fmt.Sprintf(`SELECT
+							%v,
+							SUM(r.amount)
+						FROM
+							rewards r
+							INNER JOIN gateway_inventory gi ON gi.address = r.gateway
+							INNER JOIN locations l ON l.location = gi.location
+						WHERE
+							r.time >= $1`, groupColumn)
+
+	args := []interface{}{startTimestamp}
+	if filterColumn != "" {
+		query += fmt.Sprintf(" AND %v = $2", filterColumn)
+		args = append(args, filterValue)
+	}
+
+	query += fmt.Sprintf(" GROUP BY %v", groupColumn)
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("[ERROR getPlaceRewards] %v", err)
+		return rewards
+	}
+
+	defer rows.Close()
+
+	var place sql.NullString
+	var amount sql.NullInt64
+
+	for rows.Next() {
+
+		err := rows.Scan(&place, &amount)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+
+		rewards[place.String] = amount.Int64
+	}
+
+	return rewards
+}
+
+func getCityHotspots(cityID string, limit int, offset int) []Hotspot {
+
+	hotspots := make([]Hotspot, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("locations-city-hotspots-%v-%v", cityID, offset)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			rows, err := db.DB.Query(`SELECT
+										h.address,
+										h.name,
+										h.owner,
+										h.last_poc_challenge,
+										h.first_block,
+										h.last_block,
+										h.first_timestamp,
+										h.nonce,
+										h.reward_scale,
+										h.elevation,
+										h.gain,
+										l.location,
+										l.long_country,
+										l.long_city,
+										l.long_street,
+										l.short_country
+									FROM
+										gateway_inventory h
+										INNER JOIN locations l ON l.location = h.location
+									WHERE
+										l.city_id = $1
+									ORDER BY
+										h.first_block DESC
+									LIMIT $2 OFFSET $3`, cityID, limit, offset)
+			if err != nil {
+				log.Printf("[ERROR getCityHotspots] %v", err)
+				return hotspots
+			}
+
+			defer rows.Close()
+
+			var lastPocChallenge, firstBlock, lastBlock, nonce, elevation, gain sql.NullInt64
+			var rewardScale sql.NullFloat64
+			var address, name, owner, location, country, city, street, firstTimestamp, short_country sql.NullString
+
+			for rows.Next() {
+
+				err = rows.Scan(&address, &name, &owner, &lastPocChallenge, &firstBlock, &lastBlock, &firstTimestamp, &nonce, &rewardScale, &elevation, &gain, &location, &country, &city, &street, &short_country)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				firstTimestampInt := timestamptzConverter(firstTimestamp.String)
+
+				maker, payer := getHotspotMaker(address.String)
+
+				active := getHotspotStatus(address.String)
+
+				hotspots = append(hotspots, Hotspot{
+					"hotspot",
+					address.String,
+					name.String,
+					owner.String,
+					Location{
+						location.String,
+						country.String,
+						short_country.String,
+						city.String,
+						street.String,
+					},
+					lastPocChallenge.Int64,
+					firstBlock.Int64,
+					lastBlock.Int64,
+					firstTimestampInt,
+					nonce.Int64,
+					rewardScale.Float64,
+					elevation.Int64,
+					gain.Int64,
+					maker,
+					payer,
+					active.Active,
+					active.Timestamp,
+					active.TX,
+					false, // set by markDenylisted after the cache read
+				})
+			}
+			rows.Close()
+
+			if err := enc.Encode(hotspots); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 300})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&hotspots); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	// The denylist reloads independently of the cache
+	markDenylisted(hotspots)
+
+	return hotspots
+}
+
+// getPlacesByName resolves a search query against city, state and country names
+func getPlacesByName(query string) []PlaceSearch {
+
+	places := make([]PlaceSearch, 0)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	// Queries can hold characters memcache keys don't accept
+	key := sha1.Sum([]byte(query))
+	cacheName := fmt.Sprintf("search-places-%v", hex.EncodeToString(key[:]))
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			// Escape LIKE wildcards and match on the start of the name
+			searchName := strings.ToLower(strings.TrimSpace(query))
+			searchName = strings.ReplaceAll(searchName, `\`, `\\`)
+			searchName = strings.ReplaceAll(searchName, "%", `\%`)
+			searchName = strings.ReplaceAll(searchName, "_", `\_`)
+			searchName = searchName + "%"
+
+			rows, err := db.DB.Query(`(SELECT
+										'country',
+										l.short_country,
+										MAX(l.long_country),
+										'',
+										'',
+										l.short_country,
+										MAX(l.long_country),
+										COUNT(gi.address) AS hotspots
+									FROM
+										locations l
+										INNER JOIN gateway_inventory gi ON gi.location = l.location
+									WHERE
+										LOWER(l.long_country) LIKE $1
+									GROUP BY
+										l.short_country
+									ORDER BY
+										hotspots DESC
+									LIMIT 5)
+									UNION ALL
+									(SELECT
+										'state',
+										l.short_state,
+										MAX(l.long_state),
+										l.short_state,
+										MAX(l.long_state),
+										l.short_country,
+										MAX(l.long_country),
+										COUNT(gi.address) AS hotspots
+									FROM
+										locations l
+										INNER JOIN gateway_inventory gi ON gi.location = l.location
+									WHERE
+										LOWER(l.long_state) LIKE $1
+									GROUP BY
+										l.short_country,
+										l.short_state
+									ORDER BY
+										hotspots DESC
+									LIMIT 5)
+									UNION ALL
+									(SELECT
+										'city',
+										l.city_id,
+										MAX(l.long_city),
+										MAX(l.short_state),
+										MAX(l.long_state),
+										MAX(l.short_country),
+										MAX(l.long_country),
+										COUNT(gi.address) AS hotspots
+									FROM
+										locations l
+										INNER JOIN gateway_inventory gi ON gi.location = l.location
+									WHERE
+										LOWER(l.long_city) LIKE $1
+										AND l.city_id IS NOT NULL
+										AND l.city_id != ''
+									GROUP BY
+										l.city_id
+									ORDER BY
+										hotspots DESC
+									LIMIT 10)`, searchName)
+			if err != nil {
+				log.Printf("[ERROR getPlacesByName] %v", err)
+				return places
+			}
+
+			defer rows.Close()
+
+			var level, id, name, shortState, longState, shortCountry, longCountry sql.NullString
+			var hotspots sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&level, &id, &name, &shortState, &longState, &shortCountry, &longCountry, &hotspots)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				if !id.Valid || id.String == "" {
+					continue
+				}
+
+				link := ""
+				switch level.String {
+				case "country":
+					link = fmt.Sprintf("/api/v1/hotspots/?country=%v", url.QueryEscape(shortCountry.String))
+				case "state":
+					link = fmt.Sprintf("/api/v1/hotspots/?country=%v&state=%v", url.QueryEscape(shortCountry.String), url.QueryEscape(shortState.String))
+				case "city":
+					link = fmt.Sprintf("/api/v1/hotspots/?city=%v", url.QueryEscape(id.String))
+				}
+
+				places = append(places, PlaceSearch{
+					"place",
+					level.String,
+					id.String,
+					name.String,
+					longState.String,
+					shortCountry.String,
+					longCountry.String,
+					hotspots.Int64,
+					link,
+				})
+			}
+			rows.Close()
+
+			if err := enc.Encode(places); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&places); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return places
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/uber/h3-go/v3"
+)
+
+// Witnesses closer than 300m are invalid, most valid ones are within ~10km
+const placementMinWitnessDistance = 300
+const placementMaxWitnessDistance = 10000
+const placementMaxCandidates = 25
+const placementMaxRadius = 5
+
+func GetPlacementSimulation(c echo.Context) error {
+
+	lat, errLat := strconv.ParseFloat(c.QueryParam("lat"), 64)
+	long, errLong := strconv.ParseFloat(c.QueryParam("lng"), 64)
+
+	if errLat != nil || errLong != nil || lat < -90 || lat > 90 || long < -180 || long > 180 {
+		return c.JSON(400, "Bad request")
+	}
+
+	// Optional radius in km to look for better cells
+	radius := 0.0
+	if c.QueryParam("radius") != "" {
+		r, err := strconv.ParseFloat(c.QueryParam("radius"), 64)
+		if err != nil || r < 0 {
+			return c.JSON(400, "Bad request")
+		}
+		radius = r
+	}
+
+	if radius > placementMaxRadius {
+		radius = placementMaxRadius
+	}
+
+	simulation := getPlacementSimulation(lat, long, radius)
+
+	return c.JSON(200, simulation)
+}
+
+func getPlacementSimulation(lat float64, long float64, radius float64) PlacementSimulation {
+
+	var response PlacementSimulation
+
+	location := LatLongToH3(lat, long, 12)
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("placement-%v-%v", location, radius)
+	cacheData, err := db.MC.Get(cacheName)
+	if err != nil {
+
+		if err == memcache.ErrCacheMiss {
+
+			// Before the density model loads every scale is 1.0, don't cache that
+			ready := densityReady()
+
+			index := h3.FromString(location)
+			origin := h3.ToGeo(index)
+
+			nearby := hotspotsWithin(lat, long, placementMaxWitnessDistance)
+
+			candidates := make([]PlacementCandidate, 0)
+			for _, hotspot := range nearby {
+				distance := int(h3.PointDistM(origin, h3.ToGeo(hotspot.Index)))
+				if distance >= placementMinWitnessDistance {
+					candidates = append(candidates, PlacementCandidate{hotspot.Address, hotspot.Location, distance, 0, 0})
+				}
+			}
+
+			sort.Slice(candidates, func(i, j int) bool {
+				return candidates[i].Distance < candidates[j].Distance
+			})
+
+			if len(candidates) > placementMaxCandidates {
+				candidates = candidates[:placementMaxCandidates]
+			}
+
+			// Use the receipts of the candidates to estimate the RSSI per distance band
+			bands := getObservedRSSIBands(candidates)
+			for i := range candidates {
+				candidates[i].ExpectedRSSI, candidates[i].Samples = expectedRSSI(bands, candidates[i].Distance)
+			}
+
+			rewardScale := simulateRewardScale(index)
+
+			suggestions := make([]PlacementSuggestion, 0)
+			if radius > 0 {
+				suggestions = getPlacementSuggestions(index, radius, rewardScale)
+			}
+
+			density.RLock()
+			updated := density.updated
+			density.RUnlock()
+
+			response = PlacementSimulation{
+				location,
+				lat,
+				long,
+				rewardScale,
+				len(nearby),
+				candidates,
+				suggestions,
+				updated,
+			}
+
+			if ready {
+				if err := enc.Encode(response); err != nil {
+					log.Println("Error gob: ", err)
+				}
+
+				db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+			}
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// getObservedRSSIBands returns the median RSSI per 1km distance band from the
+// last 3 days of witness receipts of the candidate hotspots
+func getObservedRSSIBands(candidates []PlacementCandidate) map[int]rssiBand {
+
+	bands := make(map[int]rssiBand, 0)
+
+	if len(candidates) == 0 {
+		return bands
+	}
+
+	addresses := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		addresses = append(addresses, candidate.Address)
+	}
+
+	threeDaysAgo := time.Now().AddDate(0, 0, -3).Unix()
+
+	rows, err := db.DB.Query(`SELECT
+								t.fields
+							FROM
+								transaction_actors ta
+								INNER JOIN transactions t ON ta.transaction_hash = t.hash
+							WHERE
+								ta.actor = ANY($1)
+								AND ta.actor_role = 'witness'
+								AND t.time > $2
+							LIMIT 1000`, pq.Array(addresses), threeDaysAgo)
+	if err != nil {
+		log.Printf("[ERROR getObservedRSSIBands] %v", err)
+		return bands
+	}
+
+	defer rows.Close()
+
+	isCandidate := make(map[string]bool, 0)
+	for _, address := range addresses {
+		isCandidate[address] = true
+	}
+
+	signals := make(map[int][]int, 0)
+
+	var fields sql.NullString
+
+	for rows.Next() {
+
+		err := rows.Scan(&fields)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+
+		witnessList := new(WitnessStruct)
+		json.Unmarshal([]byte(fields.String), &witnessList)
+
+		for _, path := range witnessList.Path {
+
+			if path.ChallengeeLocation == "" {
+				continue
+			}
+
+			for _, witness := range path.Witnesses {
+
+				if !isCandidate[witness.Gateway] || !witness.IsValid || witness.Location == "" {
+					continue
+				}
+
+				distance := h3.PointDistM(h3.ToGeo(h3.FromString(path.ChallengeeLocation)), h3.ToGeo(h3.FromString(witness.Location)))
+				band := int(distance / 1000)
+				signals[band] = append(signals[band], witness.Signal)
+			}
+		}
+	}
+	rows.Close()
+
+	for band, values := range signals {
+		sort.Ints(values)
+		bands[band] = rssiBand{values[len(values)/2], len(values)}
+	}
+
+	return bands
+}
+
+type rssiBand struct {
+	Median  int
+	Samples int
+}
+
+// expectedRSSI picks the median of the closest distance band that has samples
+func expectedRSSI(bands map[int]rssiBand, distance int) (int, int) {
+
+	band := distance / 1000
+
+	for offset := 0; offset <= placementMaxWitnessDistance/1000; offset++ {
+		if b, ok := bands[band+offset]; ok {
+			return b.Median, b.Samples
+		}
+		if b, ok := bands[band-offset]; ok {
+			return b.Median, b.Samples
+		}
+	}
+
+	return 0, 0
+}
+
+// getPlacementSuggestions evaluates the center of every res-8 hex within the radius
+// and returns the ones with a better reward scale than the proposed location
+func getPlacementSuggestions(index h3.H3Index, radius float64, currentScale float64) []PlacementSuggestion {
+
+	suggestions := make([]PlacementSuggestion, 0)
+
+	origin := h3.ToGeo(index)
+	center := h3.ToParent(index, 8)
+	rings := int(radius*1000/800) + 1
+
+	for _, hex := range h3.KRing(center, rings) {
+
+		cell := h3.ToCenterChild(hex, 12)
+		geo := h3.ToGeo(cell)
+		distance := h3.PointDistM(origin, geo)
+
+		if distance > radius*1000 {
+			continue
+		}
+
+		scale := simulateRewardScale(cell)
+		if scale > currentScale {
+			suggestions = append(suggestions, PlacementSuggestion{h3.ToString(cell), geo.Latitude, geo.Longitude, scale, int(distance)})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].RewardScale == suggestions[j].RewardScale {
+			return suggestions[i].Distance < suggestions[j].Distance
+		}
+		return suggestions[i].RewardScale > suggestions[j].RewardScale
+	})
+
+	if len(suggestions) > 10 {
+		suggestions = suggestions[:10]
+	}
+
+	return suggestions
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"hntscan/db"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+)
+
+// Maximum span per bucket size, in days. The day span can be changed with
+// REWARDS_MAX_SPAN_DAYS, the others scale with it.
+var rewardBucketSpans = map[string]int{
+	"hour":  31,
+	"day":   366,
+	"week":  366 * 2,
+	"month": 366 * 5,
+}
+
+// rewardMaxSpan is the span allowed for a bucket size, in days, with
+// REWARDS_MAX_SPAN_DAYS applied
+func rewardMaxSpan(bucket string) (int, bool) {
+
+	maxSpan, ok := rewardBucketSpans[bucket]
+	if !ok {
+		return 0, false
+	}
+
+	maxSpan = maxSpan * envInt("REWARDS_MAX_SPAN_DAYS", rewardBucketSpans["day"]) / rewardBucketSpans["day"]
+
+	// Small REWARDS_MAX_SPAN_DAYS would round the hour span down to nothing
+	if maxSpan < 1 {
+		maxSpan = 1
+	}
+
+	return maxSpan, true
+}
+
+func GetHotspotRewardSeries(c echo.Context) error {
+	return rewardSeriesHandler(c, "gateway")
+}
+
+func GetWalletRewardSeries(c echo.Context) error {
+	return rewardSeriesHandler(c, "account")
+}
+
+func GetValidatorRewardSeries(c echo.Context) error {
+	return rewardSeriesHandler(c, "gateway")
+}
+
+func rewardSeriesHandler(c echo.Context, column string) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	from, to, bucket, location, err := parseRewardRange(c)
+	if err != nil {
+		return c.JSON(400, err.Error())
+	}
+
+	series := getRewardSeries(column, hash, from, to, bucket, location)
+
+	return c.JSON(200, series)
+}
+
+// parseRewardRange reads from/to (unix seconds), bucket and tz, defaulting to the
+// last 30 days per UTC day
+func parseRewardRange(c echo.Context) (time.Time, time.Time, string, *time.Location, error) {
+
+	// Round down to the minute so the default range can be cached
+	to := time.Unix(time.Now().Unix()-time.Now().Unix()%60, 0)
+	if c.QueryParam("to") != "" {
+		v, err := strconv.ParseInt(c.QueryParam("to"), 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", nil, fmt.Errorf("invalid to")
+		}
+		to = time.Unix(v, 0)
+	}
+
+	from := to.AddDate(0, 0, -30)
+	if c.QueryParam("from") != "" {
+		v, err := strconv.ParseInt(c.QueryParam("from"), 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", nil, fmt.Errorf("invalid from")
+		}
+		from = time.Unix(v, 0)
+	}
+
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+
+	maxSpan, ok := rewardMaxSpan(bucket)
+	if !ok {
+		return time.Time{}, time.Time{}, "", nil, fmt.Errorf("invalid bucket")
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, "", nil, fmt.Errorf("from must be before to")
+	}
+
+	if to.Sub(from) > time.Duration(maxSpan)*24*time.Hour {
+		return time.Time{}, time.Time{}, "", nil, fmt.Errorf("range exceeds %v days for %v buckets", maxSpan, bucket)
+	}
+
+	tz := c.QueryParam("tz")
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, time.Time{}, "", nil, fmt.Errorf("invalid tz")
+	}
+
+	return from, to, bucket, location, nil
+}
+
+// getRewardSeries sums rewards per bucket, with bucket boundaries at local time
+// in the given location. column is "gateway" or "account", never user input.
+func getRewardSeries(column string, hash string, from time.Time, to time.Time, bucket string, location *time.Location) RewardSeries {
+
+	response := RewardSeries{from.Unix(), to.Unix(), bucket, location.String(), make(map[int64]int64, 0), 0}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("reward-series-%v-%v-%v-%v-%v-%v", column, hash, from.Unix(), to.Unix(), bucket, location.String())
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			// Empty buckets over the whole range
+			for t := truncateToBucket(from.In(location), bucket); t.Before(to); t = nextBucket(t, bucket) {
+				response.Rewards[t.Unix()] = 0
+			}
+
+			rows, err := db.DB.Query(fmt.Sprintf(`SELECT
+													EXTRACT(EPOCH FROM date_trunc($1, to_timestamp(time) AT TIME ZONE $2) AT TIME ZONE $2)::BIGINT AS bucket,
+													SUM(amount)
+												FROM
+													rewards
+												WHERE
+													%v = $3
+													AND time >= $4
+													AND time < $5
+												GROUP BY
+													bucket`, column), bucket, location.String(), hash, from.Unix(), to.Unix())
+			if err != nil {
+				log.Printf("[ERROR getRewardSeries] %v", err)
+				return response
+			}
+
+			defer rows.Close()
+
+			var bucketTimestamp, amount sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&bucketTimestamp, &amount)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				response.Rewards[bucketTimestamp.Int64] += amount.Int64
+				response.Total += amount.Int64
+			}
+			rows.Close()
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 300})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+// truncateToBucket mirrors Postgres date_trunc, weeks start on monday
+func truncateToBucket(t time.Time, bucket string) time.Time {
+
+	year, month, day := t.Date()
+
+	switch bucket {
+	case "hour":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	}
+
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+
+	switch bucket {
+	case "hour":
+		return t.Add(time.Hour)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+
+	return t.AddDate(0, 0, 1)
+}
@@ -17,6 +17,13 @@ import (
 
 func GetOraclePrices(c echo.Context) error {
 
+	payload := getOraclePrices()
+
+	return c.JSON(200, payload)
+}
+
+func getOraclePrices() OraclePrices {
+
 	var payload OraclePrices
 
 	var buf bytes.Buffer
@@ -109,7 +116,7 @@ func GetOraclePrices(c echo.Context) error {
 		}
 	}
 
-	return c.JSON(200, payload)
+	return payload
 }
 
 func sortRewardsPerDay(rewards map[int64]int64) map[int64]int64 {
@@ -166,7 +173,7 @@ func getLast24HWalletRewards(hash string) map[int64]int64 {
 					log.Printf("[ERROR] %v", err)
 				}
 
-				rewards[time.Int64] = amount.Int64
+				rewards[time.Int64] += amount.Int64
 			}
 
 			if err := enc.Encode(rewards); err != nil {
@@ -219,7 +226,7 @@ func getLast24HHotspotRewards(hash string) map[int64]int64 {
 					log.Printf("[ERROR] %v", err)
 				}
 
-				rewards[time.Int64] = amount.Int64
+				rewards[time.Int64] += amount.Int64
 			}
 
 			if err := enc.Encode(rewards); err != nil {
@@ -240,3 +247,99 @@ func getLast24HHotspotRewards(hash string) map[int64]int64 {
 
 	return rewards
 }
+
+// Reward types always present in a breakdown, other types are added when found
+var rewardTypes = []string{"poc_challengers", "poc_challengees", "poc_witnesses", "data_credits", "consensus"}
+
+// getRewardsByType returns per-day rewards split by reward type, plus the totals
+// per type over the period. column is "gateway" or "account", never user input.
+func getRewardsByType(column string, hash string, days int) RewardTypes {
+
+	response := RewardTypes{make(map[string]map[int64]int64, 0), make(map[string]int64, 0)}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	cacheName := fmt.Sprintf("rewards-types-%v-%v-%v", column, hash, days)
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+
+			year, month, day := time.Now().Date()
+			todayDate := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+			startDate := todayDate.AddDate(0, 0, -days)
+
+			// Empty series for every type and day
+			for _, rewardType := range rewardTypes {
+				response.Series[rewardType] = emptyDaySeries(startDate, todayDate)
+				response.Totals[rewardType] = 0
+			}
+
+			rows, err := db.DB.Query(fmt.Sprintf(`SELECT
+													type,
+													(time - time %% 86400) AS day,
+													SUM(amount)
+												FROM
+													rewards
+												WHERE
+													%v = $1
+													AND time >= $2
+												GROUP BY
+													type,
+													day`, column), hash, startDate.Unix())
+			if err != nil {
+				log.Printf("[ERROR getRewardsByType] %v", err)
+				return response
+			}
+
+			defer rows.Close()
+
+			var rewardType sql.NullString
+			var dayTimestamp, amount sql.NullInt64
+
+			for rows.Next() {
+
+				err := rows.Scan(&rewardType, &dayTimestamp, &amount)
+				if err != nil {
+					log.Printf("[ERROR] %v", err)
+				}
+
+				if _, ok := response.Series[rewardType.String]; !ok {
+					response.Series[rewardType.String] = emptyDaySeries(startDate, todayDate)
+				}
+
+				response.Series[rewardType.String][dayTimestamp.Int64] += amount.Int64
+				response.Totals[rewardType.String] += amount.Int64
+			}
+			rows.Close()
+
+			if err := enc.Encode(response); err != nil {
+				log.Println("Error gob: ", err)
+			}
+
+			db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 600})
+		}
+
+	} else {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&response); err != nil {
+			log.Println("Error decode: ", err)
+		}
+	}
+
+	return response
+}
+
+func emptyDaySeries(startDate time.Time, endDate time.Time) map[int64]int64 {
+
+	series := make(map[int64]int64, 0)
+
+	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
+		series[d.Unix()] = 0
+	}
+
+	return series
+}
@@ -70,6 +70,13 @@ func Search(c echo.Context) error {
 				}
 			}
 
+			// check place names (city, state, country), last since it scans the
+			// locations table
+			places := getPlacesByName(query)
+			if len(places) != 0 {
+				return c.JSON(200, places)
+			}
+
 			return c.String(http.StatusOK, "[]")
 
 		}
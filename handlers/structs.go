@@ -178,6 +178,7 @@ type SingleHotspot struct {
 	Active            bool     `json:"active"`
 	ActivityTimestamp int64    `json:"activity_timestamp"`
 	ActivityTX        string   `json:"activity_tx"`
+	Denylisted        bool     `json:"denylisted"`
 }
 
 type Hotspot struct {
@@ -199,6 +200,7 @@ type Hotspot struct {
 	Active            bool     `json:"active"`
 	ActivityTimestamp int64    `json:"activity_timestamp"`
 	ActivityTX        string   `json:"activity_tx"`
+	Denylisted        bool     `json:"denylisted"`
 }
 
 type HotspotSearch struct {
@@ -215,6 +217,7 @@ type HotspotSearch struct {
 	RewardScale      float64  `json:"reward_scale"`
 	Elevation        int64    `json:"elevation"`
 	Gain             int64    `json:"gain"`
+	Denylisted       bool     `json:"denylisted"`
 }
 
 type Hotspots struct {
@@ -224,9 +227,10 @@ type Hotspots struct {
 }
 
 type Reward struct {
-	Days       int             `json:"days"`
-	Rewards    map[int64]int64 `json:"rewards"`
-	Rewards24H map[int64]int64 `json:"rewards_24h"`
+	Days        int             `json:"days"`
+	Rewards     map[int64]int64 `json:"rewards"`
+	Rewards24H  map[int64]int64 `json:"rewards_24h"`
+	RewardTypes RewardTypes     `json:"reward_types"`
 }
 
 type SevenDayAvgBeacons struct {
@@ -254,6 +258,7 @@ type Wallet struct {
 	Balance        WalletBalance   `json:"balance"`
 	Rewards        map[int64]int64 `json:"rewards"`
 	Rewards24H     map[int64]int64 `json:"rewards_24h"`
+	RewardTypes    *RewardTypes    `json:"reward_types,omitempty"`
 	ValidatorCount int             `json:"validator_count"`
 	LastBlock      int64           `json:"last_block"`
 }
@@ -688,12 +693,21 @@ type HotspotStruct struct {
 	Active            bool    `json:"active"`
 	ActivityTimestamp int64   `json:"activity_timestamp"`
 	ActivityTX        string  `json:"activity_tx"`
+	Online            bool    `json:"online"`
+	Relayed           bool    `json:"relayed"`
+	PeerTimestamp     int64   `json:"peer_timestamp"`
+	Status            string  `json:"status"`
+	Denylisted        bool    `json:"denylisted"`
 }
 
 type Active struct {
-	Active    bool   `json:"active"`
-	Timestamp int64  `json:"timestamp"`
-	TX        string `json:"tx"`
+	Active        bool   `json:"active"`
+	Timestamp     int64  `json:"timestamp"`
+	TX            string `json:"tx"`
+	Online        bool   `json:"online"`
+	Relayed       bool   `json:"relayed"`
+	PeerTimestamp int64  `json:"peer_timestamp"`
+	Status        string `json:"status"`
 }
 
 type GeoCode struct {
@@ -732,3 +746,471 @@ type InvalidStruct struct {
 	Address string `json:"id"`
 	Reason  string `json:"reason"`
 }
+
+type PlacementSimulation struct {
+	Location          string                `json:"location"`
+	Lat               float64               `json:"lat"`
+	Lng               float64               `json:"lng"`
+	RewardScale       float64               `json:"reward_scale"`
+	HotspotsInRange   int                   `json:"hotspots_in_range"`
+	WitnessCandidates []PlacementCandidate  `json:"witness_candidates"`
+	Suggestions       []PlacementSuggestion `json:"suggestions"`
+	ModelUpdated      int64                 `json:"model_updated"`
+}
+
+type PlacementCandidate struct {
+	Address      string `json:"address"`
+	Location     string `json:"location"`
+	Distance     int    `json:"distance"`
+	ExpectedRSSI int    `json:"expected_rssi"`
+	Samples      int    `json:"samples"`
+}
+
+type PlacementSuggestion struct {
+	Location    string  `json:"location"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	RewardScale float64 `json:"reward_scale"`
+	Distance    int     `json:"distance"`
+}
+
+type PlaceStats struct {
+	DataType     string `json:"data_type"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	State        string `json:"state,omitempty"`
+	ShortCountry string `json:"short_country"`
+	Country      string `json:"country"`
+	Hotspots     int64  `json:"hotspots"`
+	Online       int64  `json:"online"`
+	Rewards30D   int64  `json:"rewards_30d"`
+}
+
+type CityHotspots struct {
+	PlaceStats
+	Hotspots []Hotspot `json:"hotspot_list"`
+}
+
+type PlaceLeaderboard struct {
+	Rank              int     `json:"rank"`
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	ShortCountry      string  `json:"short_country"`
+	Period            int     `json:"period"`
+	Hotspots          int64   `json:"hotspots"`
+	Growth            int64   `json:"growth"`
+	Online            int64   `json:"online"`
+	OnlinePercentage  float64 `json:"online_percentage"`
+	Rewards           int64   `json:"rewards"`
+	RewardsPerHotspot float64 `json:"rewards_per_hotspot"`
+	UpdatedAt         int64   `json:"updated_at"`
+}
+
+type PlaceSearch struct {
+	DataType     string `json:"data_type"`
+	Level        string `json:"level"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	State        string `json:"state,omitempty"`
+	ShortCountry string `json:"short_country"`
+	Country      string `json:"country"`
+	Hotspots     int64  `json:"hotspots"`
+	HotspotsURL  string `json:"hotspots_url"`
+}
+
+type LocationHistory struct {
+	Address       string              `json:"address"`
+	Moves         int                 `json:"moves"`
+	TotalDistance int                 `json:"total_distance"`
+	Assertions    []LocationAssertion `json:"assertions"`
+}
+
+type LocationAssertion struct {
+	Hash       string   `json:"hash"`
+	Type       string   `json:"type"`
+	Block      int64    `json:"block"`
+	Time       int64    `json:"time"`
+	Location   Location `json:"location"`
+	Place      string   `json:"place"`
+	Gain       int      `json:"gain"`
+	Elevation  int      `json:"elevation"`
+	StakingFee int      `json:"staking_fee"`
+	Fee        int      `json:"fee"`
+	Payer      string   `json:"payer"`
+	Moved      bool     `json:"moved"`
+	Distance   int      `json:"distance"`
+}
+
+type TransferHotspotFields struct {
+	Fee            int    `json:"fee"`
+	Hash           string `json:"hash"`
+	Type           string `json:"type"`
+	Gateway        string `json:"gateway"`
+	Owner          string `json:"owner"`
+	Payer          string `json:"payer"`
+	Seller         string `json:"seller"`
+	Buyer          string `json:"buyer"`
+	NewOwner       string `json:"new_owner"`
+	AmountToSeller int64  `json:"amount_to_seller"`
+}
+
+type OwnershipHistory struct {
+	Address   string        `json:"address"`
+	Transfers int           `json:"transfers"`
+	Owners    []OwnerTenure `json:"owners"`
+}
+
+type OwnerTenure struct {
+	Owner         string `json:"owner"`
+	PreviousOwner string `json:"previous_owner,omitempty"`
+	Hash          string `json:"hash"`
+	Type          string `json:"type"`
+	Block         int64  `json:"block"`
+	From          int64  `json:"from"`
+	To            int64  `json:"to"`
+	SaleAmount    int64  `json:"sale_amount"`
+	Rewards       int64  `json:"rewards"`
+}
+
+type RewardTypes struct {
+	Series map[string]map[int64]int64 `json:"series"`
+	Totals map[string]int64           `json:"totals"`
+}
+
+type RewardSeries struct {
+	From     int64           `json:"from"`
+	To       int64           `json:"to"`
+	Bucket   string          `json:"bucket"`
+	Timezone string          `json:"tz"`
+	Rewards  map[int64]int64 `json:"rewards"`
+	Total    int64           `json:"total"`
+}
+
+type TimePoint struct {
+	T int64   `json:"t"`
+	V float64 `json:"v"`
+}
+
+type TimeSeries struct {
+	Bucket string      `json:"bucket"`
+	Unit   string      `json:"unit"`
+	From   int64       `json:"from"`
+	To     int64       `json:"to"`
+	Sum    float64     `json:"sum"`
+	Min    float64     `json:"min"`
+	Max    float64     `json:"max"`
+	Points []TimePoint `json:"points"`
+}
+
+type RewardTypesV2 struct {
+	Series map[string]TimeSeries `json:"series"`
+	Totals map[string]int64      `json:"totals"`
+}
+
+type HotspotTrendV2 struct {
+	Series TimeSeries `json:"series"`
+	Start  int        `json:"start"`
+	End    int        `json:"end"`
+}
+
+type HotspotHealth struct {
+	Address     string          `json:"address"`
+	Score       int             `json:"score"`
+	Activity    HealthActivity  `json:"activity"`
+	Beacons     HealthBeacons   `json:"beacons"`
+	Witnesses   HealthWitnesses `json:"witnesses"`
+	Rewards     HealthRewards   `json:"rewards"`
+	Relay       HealthRelay     `json:"relay"`
+	Suggestions []string        `json:"recommendations"`
+	Updated     int64           `json:"updated"`
+}
+
+type HealthActivity struct {
+	Active       bool  `json:"active"`
+	LastActivity int64 `json:"last_activity"`
+	HoursSince   int64 `json:"hours_since"`
+}
+
+type HealthBeacons struct {
+	Count            int     `json:"count_7d"`
+	LastBeacon       int64   `json:"last_beacon"`
+	AvgIntervalHours float64 `json:"avg_interval_hours"`
+}
+
+type HealthWitnesses struct {
+	Valid          int              `json:"valid"`
+	Invalid        int              `json:"invalid"`
+	ValidRatio     float64          `json:"valid_ratio"`
+	InvalidReasons []InvalidReasons `json:"top_invalid_reasons"`
+}
+
+type HealthRewards struct {
+	Last7D     int64   `json:"last_7d"`
+	Previous7D int64   `json:"previous_7d"`
+	Change     float64 `json:"change"`
+	PeerChange float64 `json:"peer_change"`
+	Peers      int     `json:"peers"`
+}
+
+type HealthRelay struct {
+	Online        bool     `json:"online"`
+	Relayed       bool     `json:"relayed"`
+	PeerTimestamp int64    `json:"peer_timestamp"`
+	ListenAddrs   []string `json:"listen_addrs"`
+}
+
+type PocActivity struct {
+	Address          string      `json:"address"`
+	Days             int         `json:"days"`
+	BeaconsSent      TimeSeries  `json:"beacons"`
+	WitnessesValid   TimeSeries  `json:"witnesses_valid"`
+	WitnessesInvalid TimeSeries  `json:"witnesses_invalid"`
+	Challenges       TimeSeries  `json:"challenges"`
+	Averages         PocAverages `json:"daily_averages"`
+}
+
+type PocAverages struct {
+	Beacons          float64 `json:"beacons"`
+	WitnessesValid   float64 `json:"witnesses_valid"`
+	WitnessesInvalid float64 `json:"witnesses_invalid"`
+	Challenges       float64 `json:"challenges"`
+}
+
+type LinkHistory struct {
+	A        string        `json:"a"`
+	B        string        `json:"b"`
+	Days     int           `json:"days"`
+	Distance int           `json:"distance"`
+	AToB     LinkDirection `json:"a_to_b"`
+	BToA     LinkDirection `json:"b_to_a"`
+}
+
+type LinkDirection struct {
+	Beaconer       string            `json:"beaconer"`
+	Witness        string            `json:"witness"`
+	Count          int               `json:"count"`
+	Valid          int               `json:"valid"`
+	Invalid        int               `json:"invalid"`
+	InvalidReasons []InvalidReasons  `json:"invalid_reasons"`
+	AvgRSSI        float64           `json:"avg_rssi"`
+	AvgSNR         float64           `json:"avg_snr"`
+	RSSI           TimeSeries        `json:"rssi"`
+	SNR            TimeSeries        `json:"snr"`
+	Interactions   []LinkInteraction `json:"interactions"`
+}
+
+type LinkInteraction struct {
+	Hash          string  `json:"hash"`
+	Block         int64   `json:"block"`
+	Time          int64   `json:"time"`
+	RSSI          int     `json:"rssi"`
+	SNR           float64 `json:"snr"`
+	Frequency     float64 `json:"frequency"`
+	Datarate      string  `json:"datarate"`
+	Channel       int     `json:"channel"`
+	Distance      int     `json:"distance"`
+	Valid         bool    `json:"valid"`
+	InvalidReason string  `json:"invalid_reason,omitempty"`
+}
+
+type WitnessGraph struct {
+	Region string      `json:"region"`
+	Days   int         `json:"days"`
+	From   int64       `json:"from"`
+	To     int64       `json:"to"`
+	Nodes  []GraphNode `json:"nodes"`
+	Edges  []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	Address  string  `json:"address"`
+	Name     string  `json:"name"`
+	Owner    string  `json:"owner"`
+	Location string  `json:"location"`
+	Lat      float64 `json:"lat"`
+	Lng      float64 `json:"lng"`
+}
+
+type GraphEdge struct {
+	Source  string  `json:"source"`
+	Target  string  `json:"target"`
+	Count   int     `json:"count"`
+	Valid   int     `json:"valid"`
+	Invalid int     `json:"invalid"`
+	AvgRSSI float64 `json:"avg_rssi"`
+}
+
+type HotspotFlags struct {
+	Address string        `json:"address"`
+	Days    int           `json:"days,omitempty"`
+	Flags   []HotspotFlag `json:"flags"`
+	Updated int64         `json:"updated"`
+}
+
+type HotspotFlag struct {
+	Type        string         `json:"type"`
+	Description string         `json:"description"`
+	Count       int            `json:"count"`
+	Evidence    []FlagEvidence `json:"evidence"`
+}
+
+type FlagEvidence struct {
+	Hash     string `json:"hash,omitempty"`
+	Time     int64  `json:"time,omitempty"`
+	Beaconer string `json:"beaconer,omitempty"`
+	Witness  string `json:"witness,omitempty"`
+	Distance int    `json:"distance,omitempty"`
+	RSSI     int    `json:"rssi,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+type AnomalyReport struct {
+	Updated     int64          `json:"updated"`
+	WindowHours int            `json:"window_hours"`
+	Counts      map[string]int `json:"counts"`
+	Total       int            `json:"total"`
+	Hotspots    []HotspotFlags `json:"hotspots"`
+}
+
+type DenylistInfo struct {
+	Version  string            `json:"version"`
+	File     string            `json:"file"`
+	Modified int64             `json:"modified"`
+	Loaded   int64             `json:"loaded"`
+	Count    int               `json:"count"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type DenylistPage struct {
+	DenylistInfo
+	Hotspots []string `json:"hotspots"`
+}
+
+type DenylistMembership struct {
+	Address    string `json:"address"`
+	Denylisted bool   `json:"denylisted"`
+	Version    string `json:"version"`
+}
+
+type HotspotBenchmark struct {
+	Address string                   `json:"address"`
+	Days    int                      `json:"days"`
+	Rewards int64                    `json:"rewards"`
+	Series  TimeSeries               `json:"series"`
+	Peers   map[string]PeerBenchmark `json:"peers"`
+	Updated int64                    `json:"updated"`
+}
+
+type PeerBenchmark struct {
+	Group          string             `json:"group"`
+	Peers          int                `json:"peers"`
+	Mean           float64            `json:"mean"`
+	Median         float64            `json:"median"`
+	Percentiles    map[string]float64 `json:"percentiles"`
+	PercentileRank float64            `json:"percentile_rank"`
+	ZScore         float64            `json:"z_score"`
+}
+
+type HotspotComparison struct {
+	Days     int               `json:"days"`
+	Buckets  []int64           `json:"buckets"`
+	Hotspots []ComparedHotspot `json:"hotspots"`
+}
+
+type ComparedHotspot struct {
+	Address          string     `json:"address"`
+	Found            bool       `json:"found"`
+	Name             string     `json:"name"`
+	Place            string     `json:"place"`
+	Location         string     `json:"location"`
+	Gain             int        `json:"gain"`
+	Elevation        int        `json:"elevation"`
+	Maker            string     `json:"maker"`
+	RewardScale      float64    `json:"reward_scale"`
+	Mode             string     `json:"mode"`
+	Status           Active     `json:"status"`
+	Rewards          TimeSeries `json:"rewards"`
+	Beacons          TimeSeries `json:"beacons"`
+	WitnessesValid   TimeSeries `json:"witnesses_valid"`
+	WitnessesInvalid TimeSeries `json:"witnesses_invalid"`
+}
+
+type RewardAlert struct {
+	Address   string  `json:"address"`
+	Owner     string  `json:"owner"`
+	Day       int64   `json:"day"`
+	Rewards   int64   `json:"rewards_24h"`
+	Baseline  float64 `json:"baseline"`
+	ZScore    float64 `json:"z_score"`
+	Ratio     float64 `json:"ratio"`
+	Peers     int     `json:"peers"`
+	PeerRatio float64 `json:"peer_ratio"`
+	HexWide   bool    `json:"hex_wide"`
+	CreatedAt int64   `json:"created_at"`
+	UpdatedAt int64   `json:"updated_at"`
+}
+
+type HotspotForecast struct {
+	Address      string            `json:"address"`
+	Model        string            `json:"model"`
+	BaselineDays int               `json:"baseline_days"`
+	DailyMean    float64           `json:"daily_mean"`
+	DailyStddev  float64           `json:"daily_stddev"`
+	Price        float64           `json:"price"`
+	NextHalving  int64             `json:"next_halving"`
+	Horizons     []ForecastHorizon `json:"horizons"`
+	Updated      int64             `json:"updated"`
+}
+
+type ForecastHorizon struct {
+	Days     int     `json:"days"`
+	HNT      float64 `json:"hnt"`
+	HNTLow   float64 `json:"hnt_low"`
+	HNTHigh  float64 `json:"hnt_high"`
+	USD      float64 `json:"usd"`
+	USDLow   float64 `json:"usd_low"`
+	USDHigh  float64 `json:"usd_high"`
+	Halvings int     `json:"halvings"`
+}
+
+type AttributeHistory struct {
+	Address string            `json:"address"`
+	Changes []AttributeChange `json:"changes"`
+}
+
+type AttributeChange struct {
+	Attribute string `json:"attribute"`
+	OldValue  string `json:"old_value"`
+	NewValue  string `json:"new_value"`
+	Time      int64  `json:"time"`
+	Block     int64  `json:"block,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Source    string `json:"source"`
+}
+
+type HotspotDataTransfer struct {
+	Address      string          `json:"address"`
+	Days         int             `json:"days"`
+	TotalPackets int64           `json:"total_packets"`
+	TotalDCs     int64           `json:"total_dcs"`
+	Packets      TimeSeries      `json:"packets"`
+	DCs          TimeSeries      `json:"dcs"`
+	Routers      []RouterTraffic `json:"routers"`
+}
+
+type RouterTraffic struct {
+	Owner         string `json:"owner"`
+	Packets       int64  `json:"packets"`
+	DCs           int64  `json:"dcs"`
+	StateChannels int64  `json:"state_channels"`
+}
+
+type DataLeaderboard struct {
+	Rank          int    `json:"rank"`
+	ID            string `json:"id"`
+	Period        int    `json:"period"`
+	Packets       int64  `json:"packets"`
+	DCs           int64  `json:"dcs"`
+	StateChannels int64  `json:"state_channels"`
+	UpdatedAt     int64  `json:"updated_at"`
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+)
+
+// newTimeSeries builds an ordered series from a timestamp keyed map. From and To
+// default to the first and last point.
+func newTimeSeries(values map[int64]float64, bucket string, unit string) TimeSeries {
+
+	points := make([]TimePoint, 0, len(values))
+
+	for t, v := range values {
+		points = append(points, TimePoint{t, v})
+	}
+
+	return newTimeSeriesPoints(points, bucket, unit)
+}
+
+// newTimeSeriesPoints keeps every point, raw series can have several values at
+// the same timestamp
+func newTimeSeriesPoints(points []TimePoint, bucket string, unit string) TimeSeries {
+
+	series := TimeSeries{Bucket: bucket, Unit: unit, Points: points}
+
+	sort.SliceStable(series.Points, func(i, j int) bool {
+		return series.Points[i].T < series.Points[j].T
+	})
+
+	for i, point := range series.Points {
+
+		series.Sum += point.V
+
+		if i == 0 || point.V < series.Min {
+			series.Min = point.V
+		}
+
+		if i == 0 || point.V > series.Max {
+			series.Max = point.V
+		}
+	}
+
+	if len(series.Points) > 0 {
+		series.From = series.Points[0].T
+		series.To = series.Points[len(series.Points)-1].T
+	}
+
+	return series
+}
+
+func newTimeSeriesInt(values map[int64]int64, bucket string, unit string) TimeSeries {
+
+	converted := make(map[int64]float64, len(values))
+	for t, v := range values {
+		converted[t] = float64(v)
+	}
+
+	return newTimeSeries(converted, bucket, unit)
+}
+
+// newTimeSeriesDays converts "2006-01-02" keyed maps to UTC day timestamps
+func newTimeSeriesDays(values map[string]int, unit string) TimeSeries {
+
+	converted := make(map[int64]float64, len(values))
+	for day, v := range values {
+		t, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			continue
+		}
+		converted[t.Unix()] = float64(v)
+	}
+
+	return newTimeSeries(converted, "day", unit)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// API v2 chart endpoints return ordered TimeSeries instead of timestamp keyed maps.
+// The v1 endpoints keep their original shape.
+
+func GetHotspotRewardSeriesV2(c echo.Context) error {
+	return rewardSeriesV2Handler(c, "gateway")
+}
+
+func GetWalletRewardSeriesV2(c echo.Context) error {
+	return rewardSeriesV2Handler(c, "account")
+}
+
+func GetValidatorRewardSeriesV2(c echo.Context) error {
+	return rewardSeriesV2Handler(c, "gateway")
+}
+
+func rewardSeriesV2Handler(c echo.Context, column string) error {
+
+	hash := c.Param("hash")
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	from, to, bucket, location, err := parseRewardRange(c)
+	if err != nil {
+		return c.JSON(400, err.Error())
+	}
+
+	rewards := getRewardSeries(column, hash, from, to, bucket, location)
+
+	series := newTimeSeriesInt(rewards.Rewards, rewards.Bucket, "bones")
+	series.From = rewards.From
+	series.To = rewards.To
+
+	return c.JSON(200, series)
+}
+
+func GetHotspotRewardTypesV2(c echo.Context) error {
+	return rewardTypesV2Handler(c, "gateway")
+}
+
+func GetWalletRewardTypesV2(c echo.Context) error {
+	return rewardTypesV2Handler(c, "account")
+}
+
+func rewardTypesV2Handler(c echo.Context, column string) error {
+
+	hash := c.Param("hash")
+
+	maxDays, _ := rewardMaxSpan("day")
+
+	days := 30
+	if c.QueryParam("days") != "" {
+		d, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || d <= 0 || d > maxDays {
+			return c.JSON(400, "Bad request")
+		}
+		days = d
+	}
+
+	if hash == "" {
+		return c.JSON(400, "Bad request")
+	}
+
+	rewardTypes := getRewardsByType(column, hash, days)
+
+	response := RewardTypesV2{make(map[string]TimeSeries, 0), rewardTypes.Totals}
+	for rewardType, values := range rewardTypes.Series {
+		response.Series[rewardType] = newTimeSeriesInt(values, "day", "bones")
+	}
+
+	return c.JSON(200, response)
+}
+
+func GetOraclePricesV2(c echo.Context) error {
+
+	prices := getOraclePrices()
+
+	return c.JSON(200, newTimeSeries(prices.Prices, "raw", "usd"))
+}
+
+func GetHotspotTrendV2(c echo.Context) error {
+
+	trend := HotspotTrend30Days()
+
+	return c.JSON(200, HotspotTrendV2{newTimeSeriesDays(trend.LastDays, "hotspots"), trend.Start, trend.End})
+}
@@ -121,6 +121,7 @@ func GetSingleWallets(c echo.Context) error {
 	walletBalance := getWalletBalance(wallet)
 	walletRewards := getWalletRewards(wallet, 30)
 	walletRewards24H := getLast24HWalletRewards(wallet)
+	walletRewardTypes := getRewardsByType("account", wallet, 30)
 	walletValidatorsCount := getWalletValidatorCount(wallet)
 	walletLastBlock := getWalletLastBlock(wallet)
 
@@ -131,6 +132,7 @@ func GetSingleWallets(c echo.Context) error {
 		walletBalance,
 		walletRewards,
 		walletRewards24H,
+		&walletRewardTypes,
 		walletValidatorsCount,
 		walletLastBlock,
 	})
@@ -173,6 +175,7 @@ func getWalletData(wallet string) []Wallet {
 	walletValidatorsCount := getWalletValidatorCount(wallet)
 	walletLastBlock := getWalletLastBlock(wallet)
 
+	// The reward type breakdown is only served by the single wallet endpoint
 	wallets = append(wallets, Wallet{
 		"wallet",
 		wallet,
@@ -180,6 +183,7 @@ func getWalletData(wallet string) []Wallet {
 		walletBalance,
 		walletRewards,
 		walletRewards24H,
+		nil,
 		walletValidatorsCount,
 		walletLastBlock,
 	})
@@ -240,7 +244,7 @@ func getWalletHotspots(hash string) []Hotspot {
 				maker, payer := getMaker(payer.String)
 				geolocation := getGeolocationData(location.String)
 
-				active := Active{false, 0, ""}
+				active := Active{}
 				hotspots = append(hotspots, Hotspot{
 					"hotspot",
 					address.String,
@@ -266,6 +270,7 @@ func getWalletHotspots(hash string) []Hotspot {
 					active.Active,
 					active.Timestamp,
 					active.TX,
+					false, // set by markDenylisted after the cache read
 				})
 
 			}
@@ -287,6 +292,9 @@ func getWalletHotspots(hash string) []Hotspot {
 		}
 	}
 
+	// The denylist reloads independently of the cache
+	markDenylisted(hotspots)
+
 	return hotspots
 }
 
@@ -548,7 +556,7 @@ func getWalletRewards(hash string, days int) map[int64]int64 {
 				query.Scan(&block, &timestamp, &amount, &txType)
 
 				if amount.Valid {
-					rewards[timestamp.Int64] = amount.Int64
+					rewards[timestamp.Int64] += amount.Int64
 				}
 			}
 
@@ -0,0 +1,593 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hntscan/db"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/uber/h3-go/v3"
+)
+
+// graphMaxHotspots is the largest region exported, GRAPH_MAX_HOTSPOTS (default 5000)
+func graphMaxHotspots() int {
+
+	return envInt("GRAPH_MAX_HOTSPOTS", 5000)
+}
+
+// GraphRegion selects the hotspots of a graph, only one of the fields is set
+type GraphRegion struct {
+	BBox []float64 // min lng, min lat, max lng, max lat
+	City string
+	Cell h3.H3Index
+}
+
+func (r GraphRegion) String() string {
+
+	switch {
+	case r.City != "":
+		return "city:" + r.City
+	case r.Cell != 0:
+		return "h3:" + h3.ToString(r.Cell)
+	}
+
+	return fmt.Sprintf("bbox:%v,%v,%v,%v", r.BBox[0], r.BBox[1], r.BBox[2], r.BBox[3])
+}
+
+// ParseGraphRegion reads a region from a bbox ("minLng,minLat,maxLng,maxLat"),
+// a city id or an H3 cell
+func ParseGraphRegion(bbox string, city string, cell string) (GraphRegion, error) {
+
+	region := GraphRegion{}
+	set := 0
+
+	if bbox != "" {
+		set++
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 {
+			return region, fmt.Errorf("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		for _, part := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return region, fmt.Errorf("invalid bbox")
+			}
+			region.BBox = append(region.BBox, v)
+		}
+		if region.BBox[0] >= region.BBox[2] || region.BBox[1] >= region.BBox[3] {
+			return region, fmt.Errorf("invalid bbox")
+		}
+	}
+
+	if city != "" {
+		set++
+		region.City = city
+	}
+
+	if cell != "" {
+		set++
+		region.Cell = h3.FromString(cell)
+		if !h3.IsValid(region.Cell) {
+			return region, fmt.Errorf("invalid h3 cell")
+		}
+	}
+
+	if set != 1 {
+		return region, fmt.Errorf("one of bbox, city or h3 is required")
+	}
+
+	return region, nil
+}
+
+// Database errors are returned as errGraphUnavailable and never cached
+var errGraphUnavailable = errors.New("witness graph unavailable, try again later")
+
+func GetWitnessGraph(c echo.Context) error {
+
+	region, err := ParseGraphRegion(c.QueryParam("bbox"), c.QueryParam("city"), c.QueryParam("h3"))
+	if err != nil {
+		return c.JSON(400, err.Error())
+	}
+
+	days := 7
+	if c.QueryParam("days") != "" {
+		v, err := strconv.Atoi(c.QueryParam("days"))
+		if err != nil || v < 1 || v > pocMaxDays() {
+			return c.JSON(400, fmt.Sprintf("days must be between 1 and %v", pocMaxDays()))
+		}
+		days = v
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+
+	graph, err := getWitnessGraph(region, days)
+	if err == errGraphUnavailable {
+		return c.JSON(500, err.Error())
+	}
+	if err != nil {
+		return c.JSON(400, err.Error())
+	}
+
+	var out bytes.Buffer
+	if err := writeWitnessGraph(&out, graph, format); err != nil {
+		return c.JSON(400, err.Error())
+	}
+
+	contentType := echo.MIMEApplicationJSONCharsetUTF8
+	if format != "json" {
+		contentType = echo.MIMEApplicationXMLCharsetUTF8
+	}
+
+	return c.Blob(200, contentType, out.Bytes())
+}
+
+// ExportWitnessGraph writes the witness graph of a region, used by the -graph command
+func ExportWitnessGraph(w io.Writer, region GraphRegion, days int, format string) error {
+
+	if days < 1 || days > pocMaxDays() {
+		return fmt.Errorf("days must be between 1 and %v", pocMaxDays())
+	}
+
+	// The command runs without the background jobs
+	if region.City == "" && !densityReady() {
+		loadDensityModel()
+	}
+
+	graph, err := getWitnessGraph(region, days)
+	if err != nil {
+		return err
+	}
+
+	return writeWitnessGraph(w, graph, format)
+}
+
+// getWitnessGraph builds the directed graph of the region: an edge goes from the
+// beaconer to the witness, only hotspots inside the region are kept
+func getWitnessGraph(region GraphRegion, days int) (WitnessGraph, error) {
+
+	var graph WitnessGraph
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	key := sha1.Sum([]byte(fmt.Sprintf("%v-%v", region, days)))
+	cacheName := fmt.Sprintf("witness-graph-%v", hex.EncodeToString(key[:]))
+	cacheData, err := db.MC.Get(cacheName)
+
+	if err == nil {
+		bufDecode := bytes.NewBuffer(cacheData.Value)
+		dec := gob.NewDecoder(bufDecode)
+
+		if err := dec.Decode(&graph); err != nil {
+			log.Println("Error decode: ", err)
+		}
+
+		return graph, nil
+	}
+
+	nodes, err := getRegionHotspots(region)
+	if err != nil {
+		return graph, err
+	}
+
+	now := time.Now()
+	graph = WitnessGraph{
+		Region: region.String(),
+		Days:   days,
+		From:   now.AddDate(0, 0, -days).Unix(),
+		To:     now.Unix(),
+		Nodes:  nodes,
+		Edges:  make([]GraphEdge, 0),
+	}
+
+	inRegion := make(map[string]bool, len(nodes))
+	addresses := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		inRegion[node.Address] = true
+		addresses = append(addresses, node.Address)
+	}
+
+	rows, err := db.DB.Query(`SELECT DISTINCT
+								t.hash,
+								t.fields
+							FROM
+								transaction_actors ta
+								INNER JOIN transactions t ON ta.transaction_hash = t.hash
+							WHERE
+								ta.actor = ANY($1)
+								AND ta.actor_role = 'challengee'
+								AND t.time >= $2`, pq.Array(addresses), graph.From)
+	if err != nil {
+		log.Printf("[ERROR getWitnessGraph] %v", err)
+		return graph, errGraphUnavailable
+	}
+
+	defer rows.Close()
+
+	type edgeKey struct {
+		source string
+		target string
+	}
+
+	edges := make(map[edgeKey]*GraphEdge, 0)
+	rssiSums := make(map[edgeKey]float64, 0)
+
+	var txHash, fields sql.NullString
+
+	for rows.Next() {
+
+		err := rows.Scan(&txHash, &fields)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+
+		receipt := new(WitnessStruct)
+		json.Unmarshal([]byte(fields.String), &receipt)
+
+		for _, path := range receipt.Path {
+
+			if !inRegion[path.Challengee] {
+				continue
+			}
+
+			for _, witness := range path.Witnesses {
+
+				if !inRegion[witness.Gateway] || witness.Gateway == path.Challengee {
+					continue
+				}
+
+				k := edgeKey{path.Challengee, witness.Gateway}
+				edge, ok := edges[k]
+				if !ok {
+					edge = &GraphEdge{Source: k.source, Target: k.target}
+					edges[k] = edge
+				}
+
+				edge.Count++
+				rssiSums[k] += float64(witness.Signal)
+
+				if witness.IsValid {
+					edge.Valid++
+				} else {
+					edge.Invalid++
+				}
+			}
+		}
+	}
+	rows.Close()
+
+	for k, edge := range edges {
+		edge.AvgRSSI = rssiSums[k] / float64(edge.Count)
+		graph.Edges = append(graph.Edges, *edge)
+	}
+
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Source == graph.Edges[j].Source {
+			return graph.Edges[i].Target < graph.Edges[j].Target
+		}
+		return graph.Edges[i].Source < graph.Edges[j].Source
+	})
+
+	if err := enc.Encode(graph); err != nil {
+		log.Println("Error gob: ", err)
+	}
+
+	db.MC.Set(&memcache.Item{Key: cacheName, Value: buf.Bytes(), Expiration: 3600})
+
+	return graph, nil
+}
+
+// getRegionHotspots lists the located hotspots of a region
+func getRegionHotspots(region GraphRegion) ([]GraphNode, error) {
+
+	nodes := make([]GraphNode, 0)
+
+	addNode := func(address, name, owner, location string, geo h3.GeoCoord) error {
+		nodes = append(nodes, GraphNode{address, name, owner, location, geo.Latitude, geo.Longitude})
+		if len(nodes) > graphMaxHotspots() {
+			return fmt.Errorf("region has more than %v hotspots", graphMaxHotspots())
+		}
+		return nil
+	}
+
+	if region.City != "" {
+		rows, err := db.DB.Query(`SELECT h.address, h.name, h.owner, h.location FROM gateway_inventory h INNER JOIN locations l ON l.location = h.location WHERE l.city_id = $1`, region.City)
+		if err != nil {
+			log.Printf("[ERROR getRegionHotspots] %v", err)
+			return nodes, errGraphUnavailable
+		}
+
+		defer rows.Close()
+
+		var address, name, owner, location sql.NullString
+
+		for rows.Next() {
+
+			err := rows.Scan(&address, &name, &owner, &location)
+			if err != nil {
+				log.Printf("[ERROR] %v", err)
+			}
+
+			index := h3.FromString(location.String)
+			if !h3.IsValid(index) {
+				continue
+			}
+
+			if err := addNode(address.String, name.String, owner.String, location.String, h3.ToGeo(index)); err != nil {
+				return nodes, err
+			}
+		}
+
+		return nodes, nil
+	}
+
+	// Cells and boxes are looked up in the density index instead of the inventory
+	if !densityReady() {
+		return nodes, errGraphUnavailable
+	}
+
+	var candidates []indexedHotspot
+
+	if region.Cell != 0 {
+		cellResolution := h3.Resolution(region.Cell)
+
+		// Coarse cells have too many res-8 children to walk
+		switch {
+		case cellResolution >= 8:
+			candidates = hotspotsInRes8(h3.ToParent(region.Cell, 8))
+		case cellResolution >= densityMinRes:
+			for _, hex := range h3.ToChildren(region.Cell, 8) {
+				candidates = append(candidates, hotspotsInRes8(hex)...)
+			}
+		default:
+			candidates = allIndexedHotspots()
+		}
+
+		filtered := candidates[:0]
+		for _, hotspot := range candidates {
+			if h3.ToParent(hotspot.Index, cellResolution) == region.Cell {
+				filtered = append(filtered, hotspot)
+			}
+		}
+		candidates = filtered
+	} else {
+		candidates = allIndexedHotspots()
+	}
+
+	for _, hotspot := range candidates {
+
+		geo := h3.ToGeo(hotspot.Index)
+
+		if len(region.BBox) == 4 && (geo.Longitude < region.BBox[0] || geo.Latitude < region.BBox[1] || geo.Longitude > region.BBox[2] || geo.Latitude > region.BBox[3]) {
+			continue
+		}
+
+		if err := addNode(hotspot.Address, hotspot.Name, hotspot.Owner, hotspot.Location, geo); err != nil {
+			return nodes, err
+		}
+	}
+
+	return nodes, nil
+}
+
+func writeWitnessGraph(w io.Writer, graph WitnessGraph, format string) error {
+
+	switch format {
+	case "json":
+		return writeWitnessGraphJSON(w, graph)
+	case "graphml":
+		return writeWitnessGraphML(w, graph)
+	case "gexf":
+		return writeWitnessGraphGEXF(w, graph)
+	}
+
+	return fmt.Errorf("format must be json, graphml or gexf")
+}
+
+// writeWitnessGraphJSON writes the nodes and an adjacency list keyed by source
+func writeWitnessGraphJSON(w io.Writer, graph WitnessGraph) error {
+
+	adjacency := make(map[string][]GraphEdge, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		adjacency[node.Address] = make([]GraphEdge, 0)
+	}
+
+	for _, edge := range graph.Edges {
+		adjacency[edge.Source] = append(adjacency[edge.Source], edge)
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		Region    string                 `json:"region"`
+		Days      int                    `json:"days"`
+		From      int64                  `json:"from"`
+		To        int64                  `json:"to"`
+		Nodes     []GraphNode            `json:"nodes"`
+		Adjacency map[string][]GraphEdge `json:"adjacency"`
+	}{graph.Region, graph.Days, graph.From, graph.To, graph.Nodes, adjacency})
+}
+
+type xmlAttr struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLNode struct {
+	ID   string    `xml:"id,attr"`
+	Data []xmlAttr `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string    `xml:"source,attr"`
+	Target string    `xml:"target,attr"`
+	Data   []xmlAttr `xml:"data"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func writeWitnessGraphML(w io.Writer, graph WitnessGraph) error {
+
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"name", "node", "name", "string"},
+			{"owner", "node", "owner", "string"},
+			{"location", "node", "location", "string"},
+			{"lat", "node", "lat", "double"},
+			{"lng", "node", "lng", "double"},
+			{"count", "edge", "count", "int"},
+			{"valid", "edge", "valid", "int"},
+			{"invalid", "edge", "invalid", "int"},
+			{"avg_rssi", "edge", "avg_rssi", "double"},
+			{"weight", "edge", "weight", "double"},
+		},
+	}
+
+	doc.Graph.ID = graph.Region
+	doc.Graph.EdgeDefault = "directed"
+
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{node.Address, []xmlAttr{
+			{"name", node.Name},
+			{"owner", node.Owner},
+			{"location", node.Location},
+			{"lat", fmt.Sprint(node.Lat)},
+			{"lng", fmt.Sprint(node.Lng)},
+		}})
+	}
+
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{edge.Source, edge.Target, []xmlAttr{
+			{"count", fmt.Sprint(edge.Count)},
+			{"valid", fmt.Sprint(edge.Valid)},
+			{"invalid", fmt.Sprint(edge.Invalid)},
+			{"avg_rssi", fmt.Sprint(edge.AvgRSSI)},
+			{"weight", fmt.Sprint(edge.Valid)},
+		}})
+	}
+
+	io.WriteString(w, xml.Header)
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Weight    int            `xml:"weight,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+		Attributes      []gexfAttributes `xml:"attributes"`
+		Nodes           []gexfNode       `xml:"nodes>node"`
+		Edges           []gexfEdge       `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+func writeWitnessGraphGEXF(w io.Writer, graph WitnessGraph) error {
+
+	doc := gexf{XMLNS: "http://www.gexf.net/1.2draft", Version: "1.2"}
+
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes = []gexfAttributes{
+		{"node", []gexfAttribute{
+			{"owner", "owner", "string"},
+			{"location", "location", "string"},
+			{"lat", "lat", "double"},
+			{"lng", "lng", "double"},
+		}},
+		{"edge", []gexfAttribute{
+			{"count", "count", "integer"},
+			{"invalid", "invalid", "integer"},
+			{"avg_rssi", "avg_rssi", "double"},
+		}},
+	}
+
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{node.Address, node.Name, []gexfAttValue{
+			{"owner", node.Owner},
+			{"location", node.Location},
+			{"lat", fmt.Sprint(node.Lat)},
+			{"lng", fmt.Sprint(node.Lng)},
+		}})
+	}
+
+	for i, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{strconv.Itoa(i), edge.Source, edge.Target, edge.Valid, []gexfAttValue{
+			{"count", fmt.Sprint(edge.Count)},
+			{"invalid", fmt.Sprint(edge.Invalid)},
+			{"avg_rssi", fmt.Sprint(edge.AvgRSSI)},
+		}})
+	}
+
+	io.WriteString(w, xml.Header)
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
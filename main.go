@@ -9,7 +9,9 @@ import (
 	"flag"
 	"hntscan/db"
 	"hntscan/handlers"
+	"log"
 	"net/http"
+	"os"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -17,13 +19,44 @@ import (
 
 func main() {
 
-	// Start database connection
-	db.Start()
-
 	// Get parameter to know if running on dev or production
 	DEV := flag.Bool("dev", false, "Run in development mode")
+
+	// Witness graph export, runs instead of the server
+	graphFormat := flag.String("graph", "", "Export the witness graph (json, graphml or gexf) and exit")
+	graphBBox := flag.String("bbox", "", "Graph region as minLng,minLat,maxLng,maxLat")
+	graphCity := flag.String("city", "", "Graph region as a city id")
+	graphCell := flag.String("h3", "", "Graph region as an H3 cell")
+	graphDays := flag.Int("days", 7, "Graph window in days")
+	graphOut := flag.String("out", "", "Graph output file, stdout if empty")
 	flag.Parse()
 
+	// Start database connection
+	db.Start()
+
+	if *graphFormat != "" {
+		if err := exportWitnessGraph(*graphFormat, *graphBBox, *graphCity, *graphCell, *graphDays, *graphOut); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		return
+	}
+
+	// Start the in-memory hotspot density model
+	handlers.StartDensityModel()
+
+	// Load the offline reverse geocoder
+	handlers.StartGeocoder()
+
+	// Load the local denylist
+	handlers.StartDenylist()
+
+	// Start the scheduled jobs
+	handlers.StartLeaderboardJob()
+	handlers.StartAnomalyJob()
+	handlers.StartRewardAlertJob()
+	handlers.StartAttributeSnapshotJob()
+	handlers.StartDataLeaderboardJob()
+
 	serverPort := ":1122"
 	if *DEV {
 		serverPort = ":8082"
@@ -70,20 +103,94 @@ func main() {
 	apiGroup.GET("/hotspots/avgbeacons/:hash/", handlers.GetSingleHotspotAvgBeacons)
 	apiGroup.GET("/hotspots/status/:hash/", handlers.GetSingleHotspotStatus)
 	apiGroup.POST("/hotspots/status/", handlers.GetMultipleHotspotStatus)
+	apiGroup.POST("/hotspots/compare/", handlers.CompareHotspots)
 	apiGroup.GET("/hotspots/rewards/:hash/:days/", handlers.GetSingleHotspotRewards)
+	apiGroup.GET("/hotspots/:hash/locations/", handlers.GetHotspotLocations)
+	apiGroup.GET("/hotspots/:hash/owners/", handlers.GetHotspotOwners)
+	apiGroup.GET("/hotspots/:hash/rewards/", handlers.GetHotspotRewardSeries)
+	apiGroup.GET("/hotspots/:hash/health/", handlers.GetHotspotHealth)
+	apiGroup.GET("/hotspots/:hash/poc/", handlers.GetHotspotPocActivity)
+	apiGroup.GET("/hotspots/:a/links/:b/", handlers.GetHotspotLink)
+	apiGroup.GET("/hotspots/:hash/flags/", handlers.GetHotspotFlags)
+	apiGroup.GET("/hotspots/:hash/benchmark/", handlers.GetHotspotBenchmark)
+	apiGroup.GET("/hotspots/:hash/alerts/", handlers.GetHotspotAlerts)
+	apiGroup.GET("/hotspots/:hash/forecast/", handlers.GetHotspotForecast)
+	apiGroup.GET("/hotspots/:hash/history/", handlers.GetHotspotAttributeHistory)
+	apiGroup.GET("/hotspots/:hash/data/", handlers.GetHotspotDataTransfer)
+
+	/* LOCATIONS */
+	apiGroup.GET("/locations/countries/", handlers.GetCountries)
+	apiGroup.GET("/locations/countries/:code/cities/", handlers.GetCountryCities)
+	apiGroup.GET("/locations/cities/:city_id/hotspots/", handlers.GetCityHotspots)
+
+	/* LEADERBOARDS */
+	apiGroup.GET("/leaderboards/:level/", handlers.GetPlaceLeaderboard)
+	apiGroup.GET("/leaderboards/data/:level/", handlers.GetDataLeaderboard)
+
+	/* DENYLIST */
+	apiGroup.GET("/denylist/", handlers.GetDenylist)
+	apiGroup.GET("/denylist/:hash/", handlers.GetDenylistHotspot)
+
+	/* FLAGS */
+	apiGroup.GET("/flags/", handlers.GetAnomalyReport)
+
+	/* GRAPH */
+	apiGroup.GET("/graph/witnesses/", handlers.GetWitnessGraph)
+
+	/* PLACEMENT */
+	apiGroup.GET("/placement/", handlers.GetPlacementSimulation)
 
 	/* WALLETS */
 	apiGroup.GET("/wallets/", handlers.GetWallets)
 	apiGroup.GET("/wallets/:hash/", handlers.GetSingleWallets)
 	apiGroup.GET("/wallets/:hash/hotspots/", handlers.GetSingleWalletHotspots)
 	apiGroup.GET("/wallets/:hash/validators/", handlers.GetSingleWalletValidators)
+	apiGroup.GET("/wallets/:hash/rewards/", handlers.GetWalletRewardSeries)
+	apiGroup.GET("/wallets/:hash/alerts/", handlers.GetWalletAlerts)
 
 	/* VALIDATORS */
 	apiGroup.GET("/validators/", handlers.GetValidators)
 	apiGroup.GET("/validators/:hash/", handlers.GetSingleValidator)
+	apiGroup.GET("/validators/:hash/rewards/", handlers.GetValidatorRewardSeries)
 
 	/* PRICES */
 	apiGroup.GET("/price/oracle/", handlers.GetOraclePrices)
 
+	// V2 chart endpoints return ordered time series
+	apiV2Group := e.Group("/api/v2")
+
+	apiV2Group.GET("/hotspots/:hash/rewards/", handlers.GetHotspotRewardSeriesV2)
+	apiV2Group.GET("/hotspots/:hash/rewards/types/", handlers.GetHotspotRewardTypesV2)
+	apiV2Group.GET("/hotspots/trend/", handlers.GetHotspotTrendV2)
+	apiV2Group.GET("/wallets/:hash/rewards/", handlers.GetWalletRewardSeriesV2)
+	apiV2Group.GET("/wallets/:hash/rewards/types/", handlers.GetWalletRewardTypesV2)
+	apiV2Group.GET("/validators/:hash/rewards/", handlers.GetValidatorRewardSeriesV2)
+	apiV2Group.GET("/price/oracle/", handlers.GetOraclePricesV2)
+
 	e.Logger.Fatal(e.Start(serverPort))
 }
+
+// exportWitnessGraph returns its errors so the output file is closed before main exits
+func exportWitnessGraph(format string, bbox string, city string, cell string, days int, out string) error {
+
+	region, err := handlers.ParseGraphRegion(bbox, city, cell)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		return handlers.ExportWitnessGraph(os.Stdout, region, days, format)
+	}
+
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	if err := handlers.ExportWitnessGraph(w, region, days, format); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}